@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/schedule"
+)
+
+// runDaemon implements "go-cfgw daemon": it keeps running and syncs on SYNC_SCHEDULE,
+// reusing HTTP clients and the source cache between runs. SIGHUP reloads the
+// configuration before the next sync; SIGINT or SIGTERM stop the daemon once the
+// current sync has finished, and a second signal aborts that sync. With HTTP_ADDR it
+// serves health checks, its status and an endpoint that triggers a sync.
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Run without sending changes to Cloudflare (logs at debug level unless -log-level is set)")
+	buildLogger := logFlags(fs)
+	spec := fs.String("schedule", "", "Interval (\"30m\") or cron expression (\"0 * * * *\"), overrides SYNC_SCHEDULE")
+	jitter := fs.Duration("jitter", -1, "Random delay added to each scheduled sync, overrides SYNC_JITTER")
+	noInitial := fs.Bool("no-initial-sync", false, "Wait for the first scheduled time instead of syncing at start")
+	_ = fs.Parse(args)
+
+	logger, err := buildLogger(*dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+		return 2
+	}
+	load := func() (*syncer, schedule.Schedule, error) {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: %w", err)
+		}
+		if err := checkDaemonSources(cfg); err != nil {
+			return nil, nil, fmt.Errorf("config: %w", err)
+		}
+		if *spec != "" {
+			cfg.Schedule = *spec
+		}
+		if *jitter >= 0 {
+			cfg.ScheduleJitter = *jitter
+		}
+		sched, err := schedule.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("SYNC_SCHEDULE: %w", err)
+		}
+		return newSyncer(cfg, logger, *dryRun), sched, nil
+	}
+	s, sched, err := load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+		return 1
+	}
+	reload := func() {
+		ns, nsched, err := load()
+		if err != nil {
+			logger.Errorf("Reload failed, keeping the previous configuration: %v", err)
+			return
+		}
+		old := s
+		s, sched = ns, nsched
+		// newSyncer installed the new tracer; reloads wait for a running sync, so
+		// the old one is idle
+		shutdownTracer(old.tracer, logger)
+		logger.Infof("Configuration reloaded")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The HTTP servers keep their addresses across reloads
+	status := newDaemonStatus(s)
+	trigger := make(chan struct{}, 1)
+	if addr := s.cfg.HTTPAddr; addr != "" {
+		bound, err := serveHTTP(ctx, addr, statusHandler(status, trigger), logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "daemon: HTTP_ADDR: %v\n", err)
+			return 1
+		}
+		logger.Infof("Serving status on http://%s/status", bound)
+	}
+	if addr := s.cfg.MetricsAddr; addr != "" && addr != s.cfg.HTTPAddr {
+		bound, err := serveHTTP(ctx, addr, metricsOnly(), logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "daemon: METRICS_ADDR: %v\n", err)
+			return 1
+		}
+		logger.Infof("Serving metrics on http://%s/metrics", bound)
+	}
+
+	logger.Infof("Daemon started with schedule %q", s.cfg.Schedule)
+	next := time.Now()
+	if *noInitial {
+		next = nextRun(sched, s.cfg.ScheduleJitter)
+	}
+	for {
+		if next.IsZero() {
+			logger.Errorf("Schedule %q never fires, stopping", s.cfg.Schedule)
+			return 1
+		}
+		status.setNext(next)
+		cause := "schedule"
+		if wait := time.Until(next); wait > 0 {
+			logger.Infof("Next sync at %s", next.Format(time.RFC3339))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-trigger:
+				timer.Stop()
+				cause = "api"
+				logger.Infof("Sync requested over HTTP")
+			case sig := <-sigs:
+				timer.Stop()
+				if sig == syscall.SIGHUP {
+					reload()
+					status.reconfigure(s)
+					next = nextRun(sched, s.cfg.ScheduleJitter)
+					continue
+				}
+				logger.Infof("Received %v, stopping", sig)
+				return 0
+			}
+		}
+
+		// Sync in the background so signals are still handled while it runs
+		status.begin(cause)
+		done := make(chan error, 1)
+		go func(s *syncer) {
+			sum, err := s.run(ctx)
+			status.finish(sum, err)
+			done <- err
+		}(s)
+		stopping, reloadPending := false, false
+		for running := true; running; {
+			select {
+			case err := <-done:
+				running = false
+				switch {
+				case errors.Is(err, errBusy):
+				case err != nil:
+					logger.Errorf("Sync failed: %v", err)
+				default:
+					logger.Infof("Sync finished")
+				}
+			case sig := <-sigs:
+				switch {
+				case sig == syscall.SIGHUP:
+					reloadPending = true
+					logger.Infof("Received SIGHUP, reloading the configuration after the current sync")
+				case stopping:
+					logger.Warnf("Received %v again, aborting the current sync", sig)
+					cancel()
+				default:
+					stopping = true
+					logger.Infof("Received %v, stopping after the current sync (send again to abort it)", sig)
+				}
+			}
+		}
+		if stopping {
+			return 0
+		}
+		if reloadPending {
+			reload()
+			status.reconfigure(s)
+		}
+		next = nextRun(sched, s.cfg.ScheduleJitter)
+	}
+}
+
+// nextRun returns the next scheduled time plus a random jitter, so that many daemons
+// on the same schedule do not hit the sources and the API at once.
+func nextRun(sched schedule.Schedule, jitter time.Duration) time.Time {
+	next := sched.Next(time.Now())
+	if next.IsZero() || jitter <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(jitter))))
+}
+
+// checkDaemonSources rejects sources a daemon cannot read on every sync. Stdin is
+// consumed by the first one, so later syncs would see an empty list.
+func checkDaemonSources(cfg *config.Config) error {
+	for _, src := range append(append([]string(nil), cfg.AllowURLs...), cfg.BlockURLs...) {
+		if src == "-" {
+			return errors.New(`the stdin source "-" cannot be used in daemon mode, which reads its sources again on every sync; use a file instead`)
+		}
+	}
+	return nil
+}
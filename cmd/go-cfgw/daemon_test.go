@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/galpt/go-cfgw/internal/config"
+)
+
+func TestCheckDaemonSources(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		allow, block []string
+		ok           bool
+	}{
+		{"urls and files", []string{"./allow.txt"}, []string{"https://lists.example.com/hosts"}, true},
+		{"stdin blocklist", nil, []string{"https://lists.example.com/hosts", "-"}, false},
+		{"stdin allowlist", []string{"-"}, nil, false},
+	} {
+		err := checkDaemonSources(&config.Config{AllowURLs: tc.allow, BlockURLs: tc.block})
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case !tc.ok && (err == nil || !strings.Contains(err.Error(), "daemon mode")):
+			t.Errorf("%s: %v, want stdin rejected in daemon mode", tc.name, err)
+		}
+	}
+}
+
+func TestLogFlags(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	for _, tc := range []struct {
+		args    []string
+		verbose bool
+		want    slog.Level
+	}{
+		{nil, false, slog.LevelInfo},
+		{[]string{"-debug"}, false, slog.LevelDebug},
+		{nil, true, slog.LevelDebug},
+		{[]string{"-debug", "-log-level", "warn"}, false, slog.LevelWarn},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		build := logFlags(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+		logger, err := build(tc.verbose)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+		if !logger.Enabled(tc.want) || (tc.want > slog.LevelDebug && logger.Enabled(tc.want-4)) {
+			t.Errorf("%v, verbose %v: want level %v", tc.args, tc.verbose, tc.want)
+		}
+	}
+}
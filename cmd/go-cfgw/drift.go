@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/galpt/go-cfgw/internal/worker"
+)
+
+// runDrift implements "go-cfgw drift": it compares the lists and rules in Cloudflare
+// with the state file and optionally repairs them with a sync. It exits with 3 when
+// drift was found and not repaired.
+func runDrift(args []string) int {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	quick := fs.Bool("quick", false, "Only compare names, item counts and rules, without fetching list items")
+	repair := fs.Bool("repair", false, "Run a sync that rewrites drifted lists and rules")
+	buildLogger := logFlags(fs)
+	_ = fs.Parse(args)
+
+	ctx := context.Background()
+	logger, err := buildLogger(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "drift: %v\n", err)
+		return 2
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "drift: config: %v\n", err)
+		return 1
+	}
+	mode := worker.DriftFull
+	if *quick {
+		mode = worker.DriftQuick
+	}
+
+	if *repair {
+		cfg.DriftCheck, cfg.DriftAction = mode, worker.DriftRepair
+		if _, err := newSyncer(cfg, logger, false).run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "drift: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	drifts, err := worker.New(worker.Options{Logger: logger}).Drift(ctx, cfg, mode == worker.DriftFull)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "drift: %v\n", err)
+		return 1
+	}
+	if len(drifts) == 0 {
+		fmt.Println("No drift: Cloudflare matches the recorded state")
+		return 0
+	}
+	fmt.Printf("%d resource(s) drifted:\n", len(drifts))
+	for _, d := range drifts {
+		fmt.Printf("  %s\n", d)
+	}
+	fmt.Println("Run \"go-cfgw drift -repair\" to restore them; syncs also repair drift unless DRIFT_ACTION=abort")
+	return 3
+}
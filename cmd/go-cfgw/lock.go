@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/cf"
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/lock"
+	"github.com/galpt/go-cfgw/internal/logging"
+)
+
+// errBusy is returned by syncer.run when another sync still holds a lock after LOCK_WAIT.
+// It is not a failure: the other run does the work.
+var errBusy = errors.New("another sync is running")
+
+// remotePollInterval is how often a waiting run checks the remote lock.
+const remotePollInterval = 15 * time.Second
+
+// acquireLocks takes the local lock file and, if enabled, the remote lease on
+// Cloudflare. The returned function releases both.
+func acquireLocks(ctx context.Context, cfg *config.Config, logger *logging.Logger, dryRun bool) (func(), error) {
+	holder := lockHolder()
+	var releases []func()
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	if cfg.LockFile != "" {
+		if cfg.LockWait > 0 {
+			logger.Debugf("Waiting up to %v for lock %s", cfg.LockWait, cfg.LockFile)
+		}
+		l, err := lock.Acquire(ctx, cfg.LockFile, holder, cfg.LockWait)
+		if errors.Is(err, lock.ErrLocked) {
+			logger.Infof("Another sync is running (%s), exiting", lock.Holder(cfg.LockFile))
+			return nil, errBusy
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", cfg.LockFile, err)
+		}
+		releases = append(releases, func() {
+			if err := l.Release(); err != nil {
+				logger.Warnf("release lock %s: %v", cfg.LockFile, err)
+			}
+		})
+	}
+
+	// A dry-run changes nothing in Cloudflare, so it does not need the remote lock
+	if !cfg.RemoteLock || dryRun {
+		return release, nil
+	}
+	client := cf.NewClient(cfg, logger)
+	deadline := time.Now().Add(cfg.LockWait)
+	for {
+		lease, err := client.AcquireLease(ctx, holder, cfg.RemoteLockTTL)
+		var lerr *cf.LeaseError
+		if errors.As(err, &lerr) {
+			if time.Now().Before(deadline) {
+				logger.Infof("Waiting for the remote lock: %v", lerr)
+				select {
+				case <-ctx.Done():
+					release()
+					return nil, ctx.Err()
+				case <-time.After(remotePollInterval):
+				}
+				continue
+			}
+			release()
+			logger.Infof("Another sync is running (%v), exiting", lerr)
+			return nil, errBusy
+		}
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("remote lock: %w", err)
+		}
+
+		// Keep the lease alive while the sync runs
+		renewCtx, stop := context.WithCancel(context.Background())
+		go func() {
+			t := time.NewTicker(cfg.RemoteLockTTL / 3)
+			defer t.Stop()
+			for {
+				select {
+				case <-renewCtx.Done():
+					return
+				case <-t.C:
+					if err := lease.Renew(renewCtx); err != nil && renewCtx.Err() == nil {
+						logger.Warnf("renew remote lock: %v", err)
+					}
+				}
+			}
+		}()
+		releases = append(releases, func() {
+			stop()
+			if err := lease.Release(context.Background()); err != nil {
+				logger.Warnf("release remote lock: %v", err)
+			}
+		})
+		return release, nil
+	}
+}
+
+// lockHolder describes this process for whoever finds the lock taken.
+func lockHolder() string {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s pid %d", host, os.Getpid())
+	if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+		holder += " (GitHub Actions run " + id + ")"
+	}
+	return holder
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+)
+
+// logFlags registers the logging flags every command shares, -debug and -log-level.
+// The returned function builds the logger once fs was parsed; verbose (-dry-run) logs
+// at debug level like -debug does.
+func logFlags(fs *flag.FlagSet) func(verbose bool) (*logging.Logger, error) {
+	debug := fs.Bool("debug", false, "Enable debug logging, same as -log-level debug")
+	level := fs.String("log-level", "", "Log level: debug, info, warn or error, overrides LOG_LEVEL")
+	return func(verbose bool) (*logging.Logger, error) {
+		return newLogger(*level, *debug || verbose)
+	}
+}
+
+// newLogger builds the logger from the -log-level flag, falling back to LOG_LEVEL and
+// then to debug (for -debug and -dry-run) or info. LOG_FORMAT picks text or JSON.
+func newLogger(flagLevel string, debug bool) (*logging.Logger, error) {
+	envLevel, envFormat := config.LogSettingsFromEnv()
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	for _, s := range []struct{ name, value string }{{"LOG_LEVEL", envLevel}, {"-log-level", flagLevel}} {
+		if s.value == "" {
+			continue
+		}
+		l, err := logging.ParseLevel(s.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		level = l
+	}
+	format, err := logging.ParseFormat(envFormat)
+	if err != nil {
+		return nil, fmt.Errorf("LOG_FORMAT: %w", err)
+	}
+	return logging.New(logging.Options{Level: level, Format: format}), nil
+}
+
+// loadConfig reads the configuration and registers its credentials for redaction.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	logging.AddSecrets(cfg.Secrets()...)
+	return cfg, nil
+}
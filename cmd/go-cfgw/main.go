@@ -2,44 +2,176 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
 
+	"github.com/galpt/go-cfgw/internal/cf"
 	"github.com/galpt/go-cfgw/internal/config"
 	"github.com/galpt/go-cfgw/internal/downloader"
+	"github.com/galpt/go-cfgw/internal/index"
 	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/report"
+	"github.com/galpt/go-cfgw/internal/tracing"
 	"github.com/galpt/go-cfgw/internal/worker"
 )
 
 func main() {
-	ctx := context.Background()
-	// Simple flags for dry-run and debug
-	dryRun := flag.Bool("dry-run", false, "Run without sending changes to Cloudflare")
-	flag.Parse()
+	// Subcommands; without one, go-cfgw runs a sync
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "why":
+			os.Exit(runWhy(os.Args[2:]))
+		case "drift":
+			os.Exit(runDrift(os.Args[2:]))
+		case "daemon":
+			os.Exit(runDaemon(os.Args[2:]))
+		}
+	}
+
+	os.Exit(runSync(os.Args[1:]))
+}
 
-	logger := logging.NewLogger(*dryRun)
+// runSync runs a single sync. It returns the exit code rather than exiting itself, so
+// deferred cleanup such as releasing the locks always runs.
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("go-cfgw", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Run without sending changes to Cloudflare (logs at debug level unless -log-level is set)")
+	buildLogger := logFlags(fs)
+	_ = fs.Parse(args)
 
-	cfg, err := config.LoadFromEnv()
+	logger, err := buildLogger(*dryRun)
 	if err != nil {
-		logger.Fatalf("config: %v", err)
+		fmt.Fprintf(os.Stderr, "go-cfgw: %v\n", err)
+		return 2
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Errorf("config: %v", err)
+		return 1
 	}
 	if *dryRun {
 		logger.Infof("Running in dry-run mode")
 	}
 
-	dl := downloader.New(&downloader.Options{Client: nil, Logger: logger})
-	// Download and normalize lists (sequential to reduce rate hits)
+	if _, err := newSyncer(cfg, logger, *dryRun).run(context.Background()); errors.Is(err, errBusy) {
+		return 0
+	} else if err != nil {
+		logger.Errorf("%v", err)
+		return 1
+	}
+	logger.Infof("Done")
+	return 0
+}
+
+// syncer runs syncs. It is built once per configuration, so a daemon reuses the HTTP
+// clients, source cache and learned account limits across runs.
+type syncer struct {
+	cfg    *config.Config
+	logger *logging.Logger
+	dryRun bool
+	dl     *downloader.Downloader
+	client *cf.Client
+	w      *worker.Worker
+	tracer *tracing.Tracer // nil when tracing is disabled
+}
+
+func newSyncer(cfg *config.Config, logger *logging.Logger, dryRun bool) *syncer {
+	dl := downloader.New(&downloader.Options{
+		Client:             nil,
+		Logger:             logger,
+		WildcardPolicy:     cfg.WildcardPolicy,
+		CacheDir:           cfg.CacheDir,
+		CacheMaxAge:        cfg.CacheMaxAge,
+		Concurrency:        cfg.DownloadWorkers,
+		PerHostConcurrency: cfg.PerHostWorkers,
+		PerHostInterval:    cfg.PerHostInterval,
+		GuardAction:        cfg.GuardAction,
+		HistoryFile:        cfg.HistoryFile,
+		GuardRebaseline:    cfg.GuardRebaseline,
+	})
+	client := cf.NewClient(cfg, logger)
+	w := worker.New(worker.Options{Logger: logger, DryRun: dryRun, Client: client})
+	tracer := newTracer(cfg)
+	tracing.SetTracer(tracer)
+	return &syncer{cfg: cfg, logger: logger, dryRun: dryRun, dl: dl, client: client, w: w, tracer: tracer}
+}
+
+// run downloads all sources and brings Cloudflare up to date. The summary is nil if
+// the sync failed before reaching Cloudflare. A report is written whatever the outcome.
+// It holds the run locks throughout and returns errBusy if another sync holds them.
+func (s *syncer) run(ctx context.Context) (sum *worker.Summary, err error) {
+	cfg, logger := s.cfg, s.logger
+	release, err := acquireLocks(ctx, cfg, logger, s.dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer exportMetrics(cfg, logger)
+	defer flushTraces(s.tracer, logger)
+
+	ctx, span := tracing.Start(ctx, "sync", tracing.Bool("dry_run", s.dryRun))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	in := report.Input{StartedAt: time.Now(), DryRun: s.dryRun, TraceID: span.TraceID()}
+	apiBefore := s.client.Stats()
+	stopCapture := logger.Capture(slog.LevelWarn)
+	defer func() {
+		in.Summary, in.Err = sum, err
+		in.API = s.client.Stats().Sub(apiBefore)
+		in.Warnings = stopCapture()
+		s.writeReport(report.Build(in))
+	}()
+
+	// Download and normalize lists (bounded concurrency, polite per host)
 	logger.Infof("Starting download of lists...")
-	allow, block, err := dl.DownloadAndProcess(ctx, cfg)
+	start := time.Now()
+	res, err := s.dl.DownloadAndProcess(ctx, cfg)
+	in.Download = time.Since(start)
 	if err != nil {
-		logger.Fatalf("download: %v", err)
+		return nil, fmt.Errorf("download: %w", err)
 	}
-	logger.Infof("Downloaded %d allow entries and %d block entries", len(allow), len(block))
+	in.Result = res
+	logger.Infof("Downloaded %d allow entries and %d block entries", len(res.Allow), len(res.Block))
 
 	// Orchestrate Cloudflare updates
-	w := worker.New(worker.Options{Logger: logger, DryRun: *dryRun})
-	if err := w.Run(ctx, cfg, allow, block); err != nil {
-		logger.Fatalf("worker: %v", err)
+	start = time.Now()
+	sum, err = s.w.Run(ctx, cfg, res)
+	in.Cloudflare = time.Since(start)
+	if err != nil {
+		return sum, fmt.Errorf("worker: %w", err)
 	}
 
-	logger.Infof("Done")
+	// Remember where every entry came from for "go-cfgw why"
+	if cfg.IndexFile != "" {
+		if err := index.Build(res, sum.Truncation, sum.Chunks, s.dryRun).Save(cfg.IndexFile); err != nil {
+			logger.Warnf("save index: %v", err)
+		}
+	}
+
+	return sum, nil
+}
+
+// writeReport writes the sync report to the configured files and the GitHub Actions
+// job summary. Failures are logged, since reporting must never fail a sync.
+func (s *syncer) writeReport(r *report.Report) {
+	if s.cfg.ReportFile != "" {
+		if err := r.WriteJSON(s.cfg.ReportFile); err != nil {
+			s.logger.Warnf("write report: %v", err)
+		}
+	}
+	if s.cfg.ReportMarkdown != "" {
+		if err := r.WriteMarkdown(s.cfg.ReportMarkdown); err != nil {
+			s.logger.Warnf("write Markdown report: %v", err)
+		}
+	}
+	if err := r.AppendStepSummary(); err != nil {
+		s.logger.Warnf("write GitHub step summary: %v", err)
+	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/metrics"
+)
+
+// exportMetrics writes the metrics file and pushes to the Pushgateway, if configured.
+// Failures are logged, since monitoring must never fail a sync.
+func exportMetrics(cfg *config.Config, logger *logging.Logger) {
+	if cfg.MetricsFile != "" {
+		if err := metrics.WriteFile(cfg.MetricsFile); err != nil {
+			logger.Warnf("write metrics file: %v", err)
+		}
+	}
+	if cfg.PushgatewayURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		client := &http.Client{Timeout: 15 * time.Second}
+		if err := metrics.Push(ctx, client, cfg.PushgatewayURL, cfg.MetricsJob); err != nil {
+			logger.Warnf("push metrics: %v", err)
+		}
+	}
+}
+
+// metricsOnly serves just /metrics, for METRICS_ADDR.
+func metricsOnly() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
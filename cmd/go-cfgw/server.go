@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/metrics"
+	"github.com/galpt/go-cfgw/internal/state"
+	"github.com/galpt/go-cfgw/internal/worker"
+)
+
+// runStatus is the outcome of one daemon sync, as shown by /status.
+type runStatus struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   float64   `json:"duration_seconds"`
+	Result     string    `json:"result"` // "success", "error" or "busy"
+	Error      string    `json:"error,omitempty"`
+	Trigger    string    `json:"trigger,omitempty"` // "schedule" or "api", empty for a run of a previous process
+	Allow      int       `json:"allow"`
+	Block      int       `json:"block"`
+	Created    int       `json:"created"`
+	Updated    int       `json:"updated"`
+	Unchanged  int       `json:"unchanged"`
+	Deleted    int       `json:"deleted"`
+}
+
+// runningSync is the sync in progress, as shown by /status.
+type runningSync struct {
+	StartedAt time.Time `json:"started_at"`
+	Trigger   string    `json:"trigger"`
+}
+
+// listStatus is a Cloudflare list written by the last sync.
+type listStatus struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"`
+	Count int    `json:"count"`
+}
+
+// daemonStatus is the daemon's view of its syncs, shared with the HTTP handlers.
+type daemonStatus struct {
+	mu          sync.Mutex
+	started     time.Time
+	schedule    string
+	running     *runStatus // sync in progress, nil when idle
+	nextRun     time.Time
+	lastRun     *runStatus
+	lastSuccess time.Time
+	lists       []listStatus
+	dryRun      bool
+	token       string // HTTP_TOKEN, follows reloads
+}
+
+// newDaemonStatus seeds the status from the state file, so a restarted daemon reports
+// the lists of the previous sync before it has run one itself.
+func newDaemonStatus(s *syncer) *daemonStatus {
+	ds := &daemonStatus{started: time.Now().UTC(), schedule: s.cfg.Schedule, dryRun: s.dryRun, token: s.cfg.HTTPToken}
+	if s.cfg.StateFile == "" {
+		return ds
+	}
+	st, err := state.Load(s.cfg.StateFile)
+	if err != nil || st.AccountID != s.cfg.AccountID {
+		return ds
+	}
+	for _, l := range st.Lists {
+		ds.lists = append(ds.lists, listStatus{Kind: l.Kind, Name: l.Name, ID: l.ID, Count: l.Count})
+	}
+	if r := st.LastRun; r != nil {
+		ds.lastRun = &runStatus{
+			StartedAt: r.StartedAt, FinishedAt: r.FinishedAt, Duration: r.FinishedAt.Sub(r.StartedAt).Seconds(),
+			Result: "success", Error: r.Error,
+			Allow: r.Allow, Block: r.Block, Created: r.Created, Updated: r.Updated, Unchanged: r.Unchanged, Deleted: r.Deleted,
+		}
+		if r.Error != "" {
+			ds.lastRun.Result = "error"
+		} else {
+			ds.lastSuccess = r.FinishedAt
+		}
+	}
+	return ds
+}
+
+// reconfigure applies a reloaded configuration, including a rotated or removed
+// HTTP_TOKEN.
+func (ds *daemonStatus) reconfigure(s *syncer) {
+	ds.mu.Lock()
+	ds.schedule, ds.dryRun, ds.token = s.cfg.Schedule, s.dryRun, s.cfg.HTTPToken
+	ds.mu.Unlock()
+}
+
+func (ds *daemonStatus) httpToken() string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.token
+}
+
+func (ds *daemonStatus) setNext(next time.Time) {
+	ds.mu.Lock()
+	ds.nextRun = next
+	ds.mu.Unlock()
+}
+
+func (ds *daemonStatus) begin(trigger string) {
+	ds.mu.Lock()
+	ds.running = &runStatus{StartedAt: time.Now().UTC(), Trigger: trigger}
+	ds.nextRun = time.Time{}
+	ds.mu.Unlock()
+}
+
+// finish records the outcome of the running sync. sum may be nil if the sync failed
+// before reaching Cloudflare.
+func (ds *daemonStatus) finish(sum *worker.Summary, err error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	r := ds.running
+	if r == nil {
+		return
+	}
+	ds.running = nil
+	r.FinishedAt = time.Now().UTC()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	switch {
+	case errors.Is(err, errBusy):
+		// Another process did the work; keep reporting the last real sync
+		r.Result = "busy"
+		if ds.lastRun != nil {
+			return
+		}
+		// Shown by /status, but ready() does not count it as a completed sync
+	case err != nil:
+		r.Result, r.Error = "error", err.Error()
+	default:
+		r.Result = "success"
+		ds.lastSuccess = r.FinishedAt
+	}
+	if sum != nil {
+		r.Created, r.Updated, r.Unchanged, r.Deleted = sum.Created, sum.Updated, sum.Unchanged, sum.Deleted
+		lists := make([]listStatus, 0, len(sum.Chunks))
+		for _, c := range sum.Chunks {
+			lists = append(lists, listStatus{Kind: c.Kind, Name: c.Name, ID: c.ID, Count: c.Count})
+			if c.Kind == "allow" {
+				r.Allow += c.Count
+			} else {
+				r.Block += c.Count
+			}
+		}
+		if err == nil {
+			ds.lists = lists
+		}
+	}
+	ds.lastRun = r
+}
+
+// ready reports whether the last sync succeeded. A daemon that has not synced yet, or
+// only found another sync running, is not ready, unless a previous process left a
+// successful run in the state file.
+func (ds *daemonStatus) ready() (bool, string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	switch {
+	case ds.lastRun == nil || ds.lastRun.Result == "busy":
+		return false, "no sync has completed yet"
+	case ds.lastRun.Result == "error":
+		return false, "last sync failed: " + ds.lastRun.Error
+	}
+	return true, "ok"
+}
+
+func (ds *daemonStatus) MarshalJSON() ([]byte, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	out := struct {
+		State       string       `json:"state"` // "idle" or "running"
+		StartedAt   time.Time    `json:"started_at"`
+		Schedule    string       `json:"schedule"`
+		DryRun      bool         `json:"dry_run,omitempty"`
+		NextRun     *time.Time   `json:"next_run,omitempty"`
+		Running     *runningSync `json:"running,omitempty"`
+		LastRun     *runStatus   `json:"last_run,omitempty"`
+		LastSuccess *time.Time   `json:"last_success,omitempty"`
+		Lists       []listStatus `json:"lists"`
+	}{State: "idle", StartedAt: ds.started, Schedule: ds.schedule, DryRun: ds.dryRun, LastRun: ds.lastRun, Lists: ds.lists}
+	if r := ds.running; r != nil {
+		out.State = "running"
+		out.Running = &runningSync{StartedAt: r.StartedAt, Trigger: r.Trigger}
+	}
+	if !ds.nextRun.IsZero() {
+		next := ds.nextRun.UTC()
+		out.NextRun = &next
+	}
+	if !ds.lastSuccess.IsZero() {
+		out.LastSuccess = &ds.lastSuccess
+	}
+	if out.Lists == nil {
+		out.Lists = []listStatus{}
+	}
+	return json.Marshal(out)
+}
+
+// statusHandler serves the daemon's HTTP API. POST /sync sends on trigger and needs
+// "Authorization: Bearer <token>" with the current HTTP_TOKEN; without a token it is
+// disabled.
+func statusHandler(ds *daemonStatus, trigger chan<- struct{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ok, reason := ds.ready()
+		code := http.StatusOK
+		if !ok {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]string{"status": reason})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ds)
+	})
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		token := ds.httpToken()
+		switch {
+		case r.Method != http.MethodPost:
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		case token == "":
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "set HTTP_TOKEN to enable triggering syncs"})
+			return
+		case !authorized(r, token):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-cfgw"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing bearer token"})
+			return
+		}
+		select {
+		case trigger <- struct{}{}:
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "sync queued"})
+		default:
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a sync is already queued"})
+		}
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// serveHTTP serves handler on addr until ctx is done. The listener is opened before
+// returning so that a bad address is reported at startup.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler, logger *logging.Logger) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("http server %s: %v", addr, err)
+		}
+	}()
+	return ln.Addr(), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/galpt/go-cfgw/internal/config"
+)
+
+func TestReadyAfterBusy(t *testing.T) {
+	ds := newDaemonStatus(&syncer{cfg: &config.Config{}})
+	ds.begin("schedule")
+	ds.finish(nil, errBusy)
+	if ok, reason := ds.ready(); ok {
+		t.Fatalf("ready after a busy first run (%s), want not ready", reason)
+	}
+	if ds.lastRun == nil || ds.lastRun.Result != "busy" {
+		t.Errorf("last run %+v, want the busy run shown in /status", ds.lastRun)
+	}
+
+	ds.begin("schedule")
+	ds.finish(nil, nil)
+	if ok, reason := ds.ready(); !ok {
+		t.Errorf("not ready after a successful run: %s", reason)
+	}
+}
+
+func TestSyncTokenReload(t *testing.T) {
+	ds := newDaemonStatus(&syncer{cfg: &config.Config{HTTPToken: "old"}})
+	trigger := make(chan struct{}, 1)
+	h := statusHandler(ds, trigger)
+	post := func(token string) int {
+		t.Helper()
+		select { // empty the queue so the next request can be accepted
+		case <-trigger:
+		default:
+		}
+		req := httptest.NewRequest(http.MethodPost, "/sync", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("old"); code != http.StatusAccepted {
+		t.Fatalf("old token before reload: %d, want %d", code, http.StatusAccepted)
+	}
+	ds.reconfigure(&syncer{cfg: &config.Config{HTTPToken: "new"}})
+	if code := post("old"); code != http.StatusUnauthorized {
+		t.Errorf("old token after rotation: %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := post("new"); code != http.StatusAccepted {
+		t.Errorf("new token after rotation: %d, want %d", code, http.StatusAccepted)
+	}
+	ds.reconfigure(&syncer{cfg: &config.Config{}})
+	if code := post("new"); code != http.StatusForbidden {
+		t.Errorf("token after removing HTTP_TOKEN: %d, want %d", code, http.StatusForbidden)
+	}
+}
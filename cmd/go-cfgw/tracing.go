@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/tracing"
+)
+
+// newTracer returns the tracer configured by OTEL_TRACES_EXPORTER, or nil when tracing
+// is disabled.
+func newTracer(cfg *config.Config) *tracing.Tracer {
+	switch cfg.TraceExporter {
+	case "otlp":
+		return tracing.NewTracer(cfg.ServiceName, &tracing.OTLPExporter{URL: cfg.TraceEndpoint, Headers: cfg.TraceHeaders})
+	case "file":
+		if cfg.TraceFile == "" {
+			return nil
+		}
+		return tracing.NewTracer(cfg.ServiceName, &tracing.FileExporter{Path: cfg.TraceFile})
+	}
+	return nil
+}
+
+// flushTraces exports the spans of a sync. Failures are logged, since monitoring must
+// never fail a sync.
+func flushTraces(t *tracing.Tracer, logger *logging.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := t.Flush(ctx); err != nil {
+		logger.Warnf("export traces: %v", err)
+	}
+}
+
+// shutdownTracer flushes a tracer replaced by a reload, so spans recorded under the
+// previous configuration still reach its exporter.
+func shutdownTracer(t *tracing.Tracer, logger *logging.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := t.Shutdown(ctx); err != nil {
+		logger.Warnf("export traces of the previous configuration: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/index"
+)
+
+// runWhy implements "go-cfgw why <domain>": it explains from the index of the last run
+// which sources list a domain or one of its parents, and which list it landed in.
+func runWhy(args []string) int {
+	fs := flag.NewFlagSet("why", flag.ExitOnError)
+	indexFile := fs.String("index", config.IndexFileFromEnv(), "Index file written by the last sync (INDEX_FILE)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-cfgw why [-index file] <domain>...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *indexFile == "" {
+		fmt.Fprintln(os.Stderr, "why: the index is disabled (INDEX_FILE=off)")
+		return 1
+	}
+	ix, err := index.Load(*indexFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "why: %v (run a sync first)\n", err)
+		return 1
+	}
+
+	mode := ""
+	if ix.DryRun {
+		mode = ", dry-run"
+	}
+	fmt.Printf("Index from %s%s\n", ix.GeneratedAt.Local().Format("2006-01-02 15:04:05"), mode)
+	for _, domain := range fs.Args() {
+		fmt.Println()
+		printWhy(ix, domain)
+	}
+	return 0
+}
+
+func printWhy(ix *index.Index, domain string) {
+	matches := ix.Lookup(domain)
+	verdict := "not listed"
+	for _, m := range matches {
+		if m.Kind == "allow" && !m.Dropped {
+			verdict = "allowlisted"
+			break
+		}
+		if m.Kind == "block" && !m.Dropped {
+			verdict = "blocked"
+		}
+	}
+	if verdict == "not listed" && len(matches) > 0 {
+		verdict = "not listed (dropped to fit the list limits)"
+	}
+	fmt.Printf("%s: %s\n", domain, verdict)
+
+	for _, m := range matches {
+		via := ""
+		if m.Domain != strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".") {
+			via = " (parent domain)"
+		}
+		fmt.Printf("  %s %s%s\n", m.Kind, m.Domain, via)
+		for _, u := range m.Sources {
+			fmt.Printf("    listed by %s\n", u)
+		}
+		switch {
+		case m.Chunk != nil && m.Chunk.ID != "":
+			fmt.Printf("    in %s (%s)\n", m.Chunk.Name, m.Chunk.ID)
+		case m.Chunk != nil:
+			fmt.Printf("    in %s\n", m.Chunk.Name)
+		case m.Dropped:
+			fmt.Printf("    dropped to fit the list limits\n")
+		default:
+			fmt.Printf("    not written to Cloudflare\n")
+		}
+	}
+}
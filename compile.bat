@@ -1,6 +1,6 @@
 @echo off
 REM compile.bat — build go-cfgw.exe in this folder
-REM Usage: double-click or run from cmd/powershell. Requires Go 1.20+ in PATH.
+REM Usage: double-click or run from cmd/powershell. Requires Go 1.21+ in PATH.
 
 SETLOCAL
 cd /d "%~dp0"
@@ -12,7 +12,7 @@ echo Checking for Go in PATH...
 where go >nul 2>&1
 if errorlevel 1 (
   echo.
-  echo ERROR: 'go' not found in PATH. Please install Go 1.20+ and add it to PATH.
+  echo ERROR: 'go' not found in PATH. Please install Go 1.21+ and add it to PATH.
   echo https://go.dev/dl/
   pause
   exit /b 1
@@ -1,8 +1,11 @@
 module github.com/galpt/go-cfgw
 
-go 1.20
+go 1.21
 
 require (
 	github.com/cenkalti/backoff/v4 v4.1.0
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.21.0
 )
+
+require golang.org/x/sys v0.18.0 // indirect
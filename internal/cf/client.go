@@ -7,31 +7,73 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/galpt/go-cfgw/internal/config"
 	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/tracing"
 )
 
 // Client is a small Cloudflare Gateway API client with retry/backoff and rate-limit handling.
-// The HTTP client is thread-safe. Currently used single-threaded, but safe for concurrent use.
+// It is safe for concurrent use; all clients for the same credentials share one rate limiter.
 type Client struct {
 	http    *http.Client
 	token   string
 	account string
 	host    string
 	logger  *logging.Logger
+	limiter *Limiter
+	retry   RetryPolicy
+
+	mu      sync.Mutex
+	learned Limits   // limits reported by Cloudflare errors, or passed to Learn
+	plan    string   // Zero Trust rate plan found by DiscoverLimits
+	stats   APIStats // requests made by this client
 }
 
 func NewClient(cfg *config.Config, logger *logging.Logger) *Client {
 	httpClient := &http.Client{Timeout: 30 * time.Second}
-	return &Client{http: httpClient, token: cfg.APIToken, account: cfg.AccountID, host: cfg.APIHost, logger: logger}
+	limiter := sharedLimiter(cfg.APIHost+"\x00"+cfg.APIToken, cfg.APIRateLimit, cfg.APIRateBurst)
+	retry := RetryPolicy{Read: cfg.RetryRead, Write: cfg.RetryWrite, Delete: cfg.RetryDelete}
+	return &Client{http: httpClient, token: cfg.APIToken, account: cfg.AccountID, host: cfg.APIHost, logger: logger, limiter: limiter, retry: retry}
 }
 
+// doRequestWithRetry sends a request to the account's Gateway API (path is relative to
+// /accounts/{id}/gateway), retrying with backoff.
 func (c *Client) doRequestWithRetry(ctx context.Context, method, path string, body any) ([]byte, error) {
+	return c.do(ctx, method, "/gateway"+path, body, true, nil)
+}
+
+// doAccountRequest sends a request to a path relative to /accounts/{id}. Without retry
+// a single attempt is made, which suits optional probes.
+func (c *Client) doAccountRequest(ctx context.Context, method, path string, body any, retry bool) ([]byte, error) {
+	return c.do(ctx, method, path, body, retry, nil)
+}
+
+// reconcileTimeout bounds the lookup for a resource a cancelled create may have made.
+const reconcileTimeout = 30 * time.Second
+
+// reconcileFunc looks for the resource a create request was meant to make. It returns
+// a response body standing in for the create's, and false if there is no such resource.
+type reconcileFunc func(ctx context.Context) ([]byte, bool, error)
+
+// doCreate sends a create request to the Gateway API. When an attempt fails in a way
+// that leaves open whether Cloudflare created the resource, such as a timeout or a
+// server error, find is called before retrying and a resource it finds is returned
+// instead of creating a duplicate.
+func (c *Client) doCreate(ctx context.Context, path string, body any, find reconcileFunc) ([]byte, error) {
+	return c.do(ctx, "POST", "/gateway"+path, body, true, find)
+}
+
+// do sends a request to a path relative to /accounts/{id}. Temporary failures are
+// retried for as long as the retry policy allows for the method; permanent ones, such
+// as invalid input or missing permissions, are returned at once.
+func (c *Client) do(ctx context.Context, method, path string, body any, retry bool, find reconcileFunc) ([]byte, error) {
 	var bodyBytes []byte
 	if body != nil {
 		b, err := json.Marshal(body)
@@ -41,10 +83,50 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method, path string, bo
 		bodyBytes = b
 	}
 
+	// One span covers the call and a child span each attempt, so retries and the time
+	// spent between them show up in traces
+	ctx, span := tracing.StartKind(ctx, tracing.KindClient, "cloudflare "+method,
+		tracing.String("http.request.method", method), tracing.String("url.path", path))
+	defer span.End()
+
+	// Exponential backoff with max elapsed time, replaced by Retry-After when given
+	eb := backoff.NewExponentialBackOff()
+	eb.MaxElapsedTime = c.retry.maxElapsed(method)
+	hb := &hintBackOff{BackOff: eb}
+	var bo backoff.BackOff = backoff.WithContext(hb, ctx)
+	if !retry || eb.MaxElapsedTime <= 0 {
+		bo = &backoff.StopBackOff{}
+	}
+
 	var out []byte
-	operation := func() error {
+	attempt := 0
+	ambiguous := false // whether the last attempt may have succeeded despite failing
+	operation := func() (err error) {
+		attempt++
+		ctx, aspan := tracing.StartKind(ctx, tracing.KindClient, "cloudflare "+method+" attempt",
+			tracing.String("http.request.method", method), tracing.String("url.path", path), tracing.Int("http.request.resend_count", attempt-1))
+		defer func() {
+			aspan.RecordError(err)
+			aspan.End()
+		}()
+
+		if ambiguous && find != nil {
+			b, found, err := find(ctx)
+			if err != nil {
+				// Try again later rather than risk a duplicate
+				return fmt.Errorf("check for a resource created by the failed attempt: %w", err)
+			}
+			if found {
+				c.logger.Infof("%s %s failed, but Cloudflare had carried it out; using the existing resource", method, path)
+				aspan.SetAttributes(tracing.Bool("cloudflare.reconciled", true))
+				out = b
+				return nil
+			}
+		}
+		ambiguous = false
+
 		reqBody := bytes.NewReader(bodyBytes)
-		url := strings.TrimRight(c.host, "/") + "/accounts/" + c.account + "/gateway" + path
+		url := strings.TrimRight(c.host, "/") + "/accounts/" + c.account + path
 		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 		if err != nil {
 			return backoff.Permanent(err)
@@ -52,31 +134,67 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method, path string, bo
 		req.Header.Set("Authorization", "Bearer "+c.token)
 		req.Header.Set("Content-Type", "application/json")
 
+		log := c.logger.With("method", method, "path", path)
+		waited, err := c.limiter.Wait(ctx)
+		if waited > 0 {
+			c.countThrottle(waited)
+			aspan.SetAttributes(tracing.Float("ratelimit.wait_seconds", waited.Seconds()))
+		}
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		start := time.Now()
 		resp, err := c.http.Do(req)
 		if err != nil {
-			c.logger.Debugf("http.do error: %v", err)
+			c.countRequest(method, "error")
+			log.Debugf("http.do error: %v", err)
+			// The request may have reached Cloudflare before the connection failed, or
+			// before the caller gave up on it
+			ambiguous = true
 			return err
 		}
 		defer resp.Body.Close()
+		c.countRequest(method, strconv.Itoa(resp.StatusCode))
+		aspan.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+		// Cloudflare's ray ID identifies the request in support cases and its logs
+		log = log.With("status", resp.StatusCode)
+		if ray := resp.Header.Get("Cf-Ray"); ray != "" {
+			log = log.With("cf_ray", ray)
+			aspan.SetAttributes(tracing.String("cloudflare.ray", ray))
+		}
+		log.Debugf("Cloudflare API request took %v", time.Since(start).Round(time.Millisecond))
 
-		if resp.StatusCode == 429 {
-			// Respect Retry-After if present
-			if ra := resp.Header.Get("Retry-After"); ra != "" {
-				if secs, err := strconv.Atoi(ra); err == nil {
-					wait := time.Duration(secs)*time.Second + 500*time.Millisecond
-					c.logger.Infof("rate limited, waiting %v before retrying", wait)
-					time.Sleep(wait)
-				}
-			} else {
-				// default cooldown
-				c.logger.Infof("rate limited (429), backing off")
+		// Hold back every request, not only this one, while Cloudflare asks for a pause
+		now := time.Now()
+		if reset, ok := rateLimitReset(resp.Header); ok {
+			log.Debugf("rate limit exhausted, pausing requests for %v", reset)
+			c.limiter.Pause(now.Add(reset))
+		}
+		if resp.StatusCode == 429 || resp.StatusCode == 503 {
+			if wait, ok := retryAfter(resp.Header, now); ok {
+				// Half a second of margin for clock skew and rounding
+				wait += 500 * time.Millisecond
+				c.limiter.Pause(now.Add(wait))
+				hb.hint = wait
+				aspan.SetAttributes(tracing.Float("http.retry_after_seconds", wait.Seconds()))
+				log.Infof("Cloudflare answered %d, retrying in %v as asked by Retry-After", resp.StatusCode, wait.Round(time.Millisecond))
+			} else if resp.StatusCode == 429 {
+				log.Infof("rate limited (429), backing off")
 			}
-			return fmt.Errorf("rate limited: 429")
 		}
 
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			b, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("http %d: %s", resp.StatusCode, string(b))
+			aerr := newAPIError(resp.StatusCode, b)
+			// Limit errors will not go away by retrying
+			if lerr := c.learnLimit(aerr); lerr != nil {
+				return backoff.Permanent(lerr)
+			}
+			if !aerr.Temporary() {
+				return backoff.Permanent(aerr)
+			}
+			ambiguous = aerr.ambiguous()
+			return aerr
 		}
 
 		b, err := io.ReadAll(resp.Body)
@@ -87,13 +205,26 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method, path string, bo
 		return nil
 	}
 
-	// Exponential backoff with max elapsed time
-	eb := backoff.NewExponentialBackOff()
-	eb.MaxElapsedTime = 2 * time.Minute
-	bo := backoff.WithContext(eb, ctx)
-
-	err := backoff.Retry(operation, bo)
+	err := backoff.RetryNotify(operation, bo, func(_ error, d time.Duration) {
+		c.countBackoff(d)
+	})
+	if err != nil && ambiguous && find != nil {
+		// Out of retries or cancelled, but the last attempt may still have created the
+		// resource. Look for it even if the caller gave up, so it is not left orphaned.
+		fctx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			fctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), reconcileTimeout)
+			defer cancel()
+		}
+		if b, found, ferr := find(fctx); ferr == nil && found {
+			c.logger.Infof("%s %s failed, but Cloudflare had carried it out; using the existing resource", method, path)
+			out, err = b, nil
+		}
+	}
+	span.SetAttributes(tracing.Int("cloudflare.attempts", attempt))
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	return out, nil
@@ -115,7 +246,26 @@ func (c *Client) GetLists(ctx context.Context) (map[string]any, error) {
 // CreateList creates a Zero Trust list with provided items (items are objects with "value" property)
 func (c *Client) CreateList(ctx context.Context, name string, items []map[string]any) (map[string]any, error) {
 	body := map[string]any{"name": name, "type": "DOMAIN", "items": items}
-	b, err := c.doRequestWithRetry(ctx, "POST", "/lists", body)
+	b, err := c.doCreate(ctx, "/lists", body, func(ctx context.Context) ([]byte, bool, error) {
+		lists, err := c.Lists(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, l := range lists {
+			if l.Name != name {
+				continue
+			}
+			if l.Count != len(items) {
+				// Not what the create would have written; make it so
+				if err := c.UpdateList(ctx, l.ID, name, items); err != nil {
+					return nil, false, err
+				}
+			}
+			b, err := json.Marshal(map[string]any{"success": true, "result": map[string]any{"id": l.ID, "name": name, "count": len(items)}})
+			return b, true, err
+		}
+		return nil, false, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -153,31 +303,107 @@ func (c *Client) DeleteRule(ctx context.Context, id any) error {
 	return err
 }
 
+// Legacy resource names. Without a state file, resources are only recognised by these
+// exact names so lists and rules of other tools are never touched.
+var (
+	managedRuleNames = map[string]bool{
+		"CGPS Filter Lists":                          true,
+		"CGPS Filter Lists - SNI Based Filtering":    true,
+		"Go-CFGW Filter Lists":                       true,
+		"Go-CFGW Filter Lists - SNI Based Filtering": true,
+	}
+	managedListPattern = regexp.MustCompile(`^(CGPS( Block| Allow)? List|Go-CFGW (Block|Allow) List) - Chunk \d+$`)
+)
+
+// IsManagedRule reports whether a rule name is one go-cfgw or the original CGPS
+// scripts create.
+func IsManagedRule(name string) bool { return managedRuleNames[name] }
+
+// IsManagedList reports whether a list name is one go-cfgw or the original CGPS
+// scripts create, such as "CGPS List - Chunk 3" or "Go-CFGW Block List - Chunk 12".
+func IsManagedList(name string) bool { return managedListPattern.MatchString(name) }
+
+// List is a Zero Trust list as returned by Lists.
+type List struct {
+	ID          string
+	Name        string
+	Description string
+	Count       int
+	CreatedAt   time.Time
+}
+
+// Lists returns the account's Zero Trust lists.
+func (c *Client) Lists(ctx context.Context) ([]List, error) {
+	resp, err := c.GetLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []List
+	if res, ok := resp["result"].([]any); ok {
+		for _, l := range res {
+			if lmap, ok := l.(map[string]any); ok {
+				id, _ := lmap["id"].(string)
+				name, _ := lmap["name"].(string)
+				description, _ := lmap["description"].(string)
+				count, _ := lmap["count"].(float64)
+				created, _ := lmap["created_at"].(string)
+				createdAt, _ := time.Parse(time.RFC3339, created)
+				out = append(out, List{ID: id, Name: name, Description: description, Count: int(count), CreatedAt: createdAt})
+			}
+		}
+	}
+	return out, nil
+}
+
+// Rule is a Gateway rule as returned by Rules.
+type Rule struct {
+	ID         string
+	Name       string
+	Enabled    bool
+	Traffic    string
+	Precedence int
+}
+
+// Rules returns the account's Gateway rules.
+func (c *Client) Rules(ctx context.Context) ([]Rule, error) {
+	resp, err := c.GetRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Rule
+	if res, ok := resp["result"].([]any); ok {
+		for _, r := range res {
+			if rmap, ok := r.(map[string]any); ok {
+				id, _ := rmap["id"].(string)
+				name, _ := rmap["name"].(string)
+				enabled, _ := rmap["enabled"].(bool)
+				traffic, _ := rmap["traffic"].(string)
+				precedence, _ := rmap["precedence"].(float64)
+				out = append(out, Rule{ID: id, Name: name, Enabled: enabled, Traffic: traffic, Precedence: int(precedence)})
+			}
+		}
+	}
+	return out, nil
+}
+
 // DeleteAllOldRules deletes all rules matching the old naming patterns (CGPS and Go-CFGW).
 // This ensures a clean slate before creating new rules.
 func (c *Client) DeleteAllOldRules(ctx context.Context) error {
-	rulesResp, err := c.GetRules(ctx)
+	rules, err := c.Rules(ctx)
 	if err != nil {
 		return fmt.Errorf("get rules: %w", err)
 	}
 
 	deleted := 0
-	if res, ok := rulesResp["result"].([]any); ok {
-		for _, r := range res {
-			if rmap, ok := r.(map[string]any); ok {
-				ruleName, _ := rmap["name"].(string)
-				// Delete both old CGPS rules (DNS and SNI) and any existing Go-CFGW rules
-				if strings.Contains(ruleName, "CGPS Filter Lists") ||
-					strings.Contains(ruleName, "Go-CFGW Filter Lists") {
-					id := rmap["id"]
-					c.logger.Infof("Deleting old rule: %s", ruleName)
-					if err := c.DeleteRule(ctx, id); err != nil {
-						c.logger.Warnf("Failed to delete rule %s: %v", ruleName, err)
-						// Continue deleting others
-					} else {
-						deleted++
-					}
-				}
+	for _, r := range rules {
+		// Delete both old CGPS rules (DNS and SNI) and any existing Go-CFGW rules
+		if IsManagedRule(r.Name) {
+			c.logger.Infof("Deleting old rule: %s", r.Name)
+			if err := c.DeleteRule(ctx, r.ID); err != nil {
+				c.logger.Warnf("Failed to delete rule %s: %v", r.Name, err)
+				// Continue deleting others
+			} else {
+				deleted++
 			}
 		}
 	}
@@ -193,30 +419,20 @@ func (c *Client) DeleteAllOldRules(ctx context.Context) error {
 // DeleteAllOldLists deletes all lists matching the old naming patterns (CGPS and Go-CFGW).
 // This ensures a clean slate before creating new lists.
 func (c *Client) DeleteAllOldLists(ctx context.Context) error {
-	listsResp, err := c.GetLists(ctx)
+	lists, err := c.Lists(ctx)
 	if err != nil {
 		return fmt.Errorf("get lists: %w", err)
 	}
 
 	deleted := 0
-	if res, ok := listsResp["result"].([]any); ok {
-		for _, l := range res {
-			if lmap, ok := l.(map[string]any); ok {
-				listName, _ := lmap["name"].(string)
-				// Delete both old CGPS lists and any existing Go-CFGW lists
-				// Use Contains to catch all variations: "CGPS List", "CGPS Block List", etc.
-				if strings.Contains(listName, "CGPS") ||
-					strings.HasPrefix(listName, "Go-CFGW Block List") ||
-					strings.HasPrefix(listName, "Go-CFGW Allow List") {
-					id := lmap["id"]
-					c.logger.Infof("Deleting old list: %s", listName)
-					if err := c.DeleteList(ctx, id); err != nil {
-						c.logger.Warnf("Failed to delete list %s: %v", listName, err)
-						// Continue deleting others
-					} else {
-						deleted++
-					}
-				}
+	for _, l := range lists {
+		if IsManagedList(l.Name) {
+			c.logger.Infof("Deleting old list: %s", l.Name)
+			if err := c.DeleteList(ctx, l.ID); err != nil {
+				c.logger.Warnf("Failed to delete list %s: %v", l.Name, err)
+				// Continue deleting others
+			} else {
+				deleted++
 			}
 		}
 	}
@@ -229,28 +445,122 @@ func (c *Client) DeleteAllOldLists(ctx context.Context) error {
 	return nil
 }
 
-// CreateOrUpdateRule creates or updates a rule. If rule with name exists, updates it.
-func (c *Client) CreateOrUpdateRule(ctx context.Context, name string, traffic any, filters []string, blockPageEnabled bool) error {
-	// Query existing rules
-	rulesResp, err := c.GetRules(ctx)
-	if err != nil {
-		return err
+// UpdateList replaces the name and items of an existing list.
+func (c *Client) UpdateList(ctx context.Context, id, name string, items []map[string]any) error {
+	body := map[string]any{"name": name, "items": items}
+	_, err := c.doRequestWithRetry(ctx, "PUT", "/lists/"+id, body)
+	return err
+}
+
+// RuleSpec describes a go-cfgw rule.
+type RuleSpec struct {
+	Name             string
+	Traffic          string
+	Filters          []string
+	BlockPageEnabled bool
+	Precedence       int // 0 lets Cloudflare choose (create) or keeps the current one (update)
+}
+
+// body returns the request body of the rule.
+func (r RuleSpec) body() map[string]any {
+	body := map[string]any{"name": r.Name, "description": "Filter lists created by go-cfgw. Avoid editing this rule.", "enabled": true, "action": "block", "rule_settings": map[string]any{"block_page_enabled": r.BlockPageEnabled, "block_reason": "Blocked by go-cfgw, check your filter lists if this was a mistake."}, "filters": r.Filters, "traffic": r.Traffic}
+	if r.Precedence > 0 {
+		body["precedence"] = r.Precedence
 	}
-	if res, ok := rulesResp["result"].([]any); ok {
-		for _, r := range res {
-			if rmap, ok := r.(map[string]any); ok {
-				if rmap["name"] == name {
-					id := rmap["id"]
-					// Update
-					body := map[string]any{"name": name, "description": "Filter lists created by go-cfgw. Avoid editing this rule. Changing the name of this rule will break the script.", "enabled": true, "action": "block", "rule_settings": map[string]any{"block_page_enabled": blockPageEnabled, "block_reason": "Blocked by go-cfgw, check your filter lists if this was a mistake."}, "filters": filters, "traffic": traffic}
-					_, err := c.doRequestWithRetry(ctx, "PUT", "/rules/"+fmt.Sprintf("%v", id), body)
-					return err
+	return body
+}
+
+// parseRule decodes the rule of a single-rule response.
+func parseRule(b []byte) (Rule, error) {
+	var out struct {
+		Result struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			Enabled    bool   `json:"enabled"`
+			Traffic    string `json:"traffic"`
+			Precedence int    `json:"precedence"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return Rule{}, err
+	}
+	return Rule(out.Result), nil
+}
+
+// CreateRule creates a rule and returns it as stored by Cloudflare.
+func (c *Client) CreateRule(ctx context.Context, spec RuleSpec) (Rule, error) {
+	b, err := c.doCreate(ctx, "/rules", spec.body(), func(ctx context.Context) ([]byte, bool, error) {
+		rules, err := c.Rules(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, r := range rules {
+			if r.Name != spec.Name {
+				continue
+			}
+			if r.Traffic != spec.Traffic {
+				// Not what the create would have written; make it so
+				if r, err = c.UpdateRule(ctx, r.ID, spec); err != nil {
+					return nil, false, err
 				}
 			}
+			b, err := json.Marshal(map[string]any{"success": true, "result": map[string]any{
+				"id": r.ID, "name": r.Name, "enabled": r.Enabled, "traffic": r.Traffic, "precedence": r.Precedence,
+			}})
+			return b, true, err
+		}
+		return nil, false, nil
+	})
+	if err != nil {
+		return Rule{}, err
+	}
+	return parseRule(b)
+}
+
+// UpdateRule replaces an existing rule by ID and returns it as stored by Cloudflare.
+func (c *Client) UpdateRule(ctx context.Context, id string, spec RuleSpec) (Rule, error) {
+	b, err := c.doRequestWithRetry(ctx, "PUT", "/rules/"+id, spec.body())
+	if err != nil {
+		return Rule{}, err
+	}
+	return parseRule(b)
+}
+
+// ListItems returns the values of all items in a list, following pagination.
+func (c *Client) ListItems(ctx context.Context, id string) ([]string, error) {
+	var values []string
+	for page := 1; ; page++ {
+		b, err := c.doRequestWithRetry(ctx, "GET", fmt.Sprintf("/lists/%s/items?page=%d&per_page=1000", id, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var resp struct {
+			Result     json.RawMessage `json:"result"`
+			ResultInfo struct {
+				TotalCount int `json:"total_count"`
+			} `json:"result_info"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return nil, err
+		}
+		type item struct {
+			Value string `json:"value"`
+		}
+		// The API wraps the items in an extra array; accept both shapes
+		var items []item
+		var nested [][]item
+		if err := json.Unmarshal(resp.Result, &nested); err == nil {
+			for _, n := range nested {
+				items = append(items, n...)
+			}
+		} else if err := json.Unmarshal(resp.Result, &items); err != nil {
+			return nil, fmt.Errorf("decode list items: %w", err)
+		}
+		for _, it := range items {
+			values = append(values, it.Value)
+		}
+		if len(items) == 0 || len(values) >= resp.ResultInfo.TotalCount {
+			return values, nil
 		}
 	}
-	// Create
-	body := map[string]any{"name": name, "description": "Filter lists created by go-cfgw. Avoid editing this rule. Changing the name of this rule will break the script.", "enabled": true, "action": "block", "rule_settings": map[string]any{"block_page_enabled": blockPageEnabled, "block_reason": "Blocked by go-cfgw, check your filter lists if this was a mistake."}, "filters": filters, "traffic": traffic}
-	_, err = c.doRequestWithRetry(ctx, "POST", "/rules", body)
-	return err
 }
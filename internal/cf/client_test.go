@@ -0,0 +1,301 @@
+package cf_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/cf"
+	"github.com/galpt/go-cfgw/internal/cftest"
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+)
+
+// newClient returns a client for srv, configured from the environment like go-cfgw is,
+// with the client-side rate limit off. env overrides further variables.
+func newClient(t *testing.T, srv *cftest.Server, env map[string]string) *cf.Client {
+	t.Helper()
+	srv.Setenv(t)
+	t.Setenv("CLOUDFLARE_RATE_LIMIT", "off")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return cf.NewClient(cfg, logging.New(logging.Options{Output: io.Discard}))
+}
+
+func domains(n int) []map[string]any {
+	items := make([]map[string]any, n)
+	for i := range items {
+		items[i] = map[string]any{"value": fmt.Sprintf("d%05d.example.com", i)}
+	}
+	return items
+}
+
+func TestClientLists(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{MaxItemsPerList: 5000})
+	c := newClient(t, srv, nil)
+	ctx := context.Background()
+
+	resp, err := c.CreateList(ctx, "Go-CFGW Block List - Chunk 1", domains(2500))
+	if err != nil {
+		t.Fatalf("create list: %v", err)
+	}
+	id, _ := resp["result"].(map[string]any)["id"].(string)
+	lists, err := c.Lists(ctx)
+	if err != nil {
+		t.Fatalf("lists: %v", err)
+	}
+	if len(lists) != 1 || lists[0].ID != id || lists[0].Count != 2500 {
+		t.Fatalf("lists = %+v, want one list %s of 2500 items", lists, id)
+	}
+
+	items, err := c.ListItems(ctx, id)
+	if err != nil {
+		t.Fatalf("list items: %v", err)
+	}
+	if len(items) != 2500 || items[2499] != "d02499.example.com" {
+		t.Errorf("got %d items, want all 2500", len(items))
+	}
+	if n := srv.Count("GET", "/gateway/lists/"+id+"/items"); n != 3 {
+		t.Errorf("fetched items in %d pages, want 3", n)
+	}
+
+	if err := c.UpdateList(ctx, id, "Go-CFGW Block List - Chunk 1", domains(10)); err != nil {
+		t.Fatalf("update list: %v", err)
+	}
+	if got := srv.Lists()[0].Items; len(got) != 10 {
+		t.Errorf("list has %d items after update, want 10", len(got))
+	}
+	if err := c.DeleteList(ctx, id); err != nil {
+		t.Fatalf("delete list: %v", err)
+	}
+	if got := srv.Lists(); len(got) != 0 {
+		t.Errorf("lists left after delete: %+v", got)
+	}
+}
+
+func TestClientRules(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	ctx := context.Background()
+	l := srv.AddList("Go-CFGW Block List - Chunk 1", "example.com")
+
+	spec := cf.RuleSpec{Name: "Go-CFGW Filter Lists", Traffic: "any(dns.domains[*] in $" + l.ID + ")", Filters: []string{"dns"}}
+	r, err := c.CreateRule(ctx, spec)
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	if r.ID == "" || !r.Enabled || r.Traffic != spec.Traffic || r.Precedence == 0 {
+		t.Fatalf("created rule = %+v", r)
+	}
+
+	spec.BlockPageEnabled = true
+	if _, err := c.UpdateRule(ctx, r.ID, spec); err != nil {
+		t.Fatalf("update rule: %v", err)
+	}
+	got := srv.Rules()
+	if len(got) != 1 || !got[0].BlockPageEnabled || got[0].Precedence != r.Precedence {
+		t.Errorf("rules after update = %+v, want block page on and precedence %d kept", got, r.Precedence)
+	}
+
+	// The list is in use until the rule is gone
+	if err := c.DeleteList(ctx, l.ID); err == nil {
+		t.Errorf("deleted a list a rule references")
+	}
+	if err := c.DeleteRule(ctx, r.ID); err != nil {
+		t.Fatalf("delete rule: %v", err)
+	}
+	if err := c.DeleteList(ctx, l.ID); err != nil {
+		t.Errorf("delete list: %v", err)
+	}
+}
+
+func TestClientRetriesRateLimited(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	srv.Inject(cftest.RateLimited(1))
+
+	start := time.Now()
+	if _, err := c.Lists(context.Background()); err != nil {
+		t.Fatalf("lists: %v", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %v, want at least the 1s of Retry-After", d)
+	}
+	if st := c.Stats(); st.Requests != 2 || st.RateLimited != 1 {
+		t.Errorf("stats = %+v, want 2 requests of which 1 rate limited", st)
+	}
+}
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	srv.Inject(cftest.Fault{Method: "GET", Status: http.StatusBadGateway, Times: 2})
+
+	if _, err := c.Rules(context.Background()); err != nil {
+		t.Fatalf("rules: %v", err)
+	}
+	if n := srv.Count("GET", "/gateway/rules"); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+}
+
+func TestClientPermanentError(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	srv.Inject(cftest.Fault{Method: "POST", Status: http.StatusBadRequest, Code: 2001, Message: "invalid list"})
+
+	_, err := c.CreateList(context.Background(), "Go-CFGW Block List - Chunk 1", domains(1))
+	var aerr *cf.APIError
+	if !errors.As(err, &aerr) || aerr.Status != http.StatusBadRequest || aerr.Temporary() {
+		t.Fatalf("err = %v, want a permanent 400 APIError", err)
+	}
+	if n := srv.Count("POST", "/gateway/lists"); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestClientRetryBudget(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, map[string]string{"CLOUDFLARE_RETRY_WRITE": "0"})
+	srv.Inject(cftest.Fault{Method: "PUT", Status: http.StatusServiceUnavailable})
+
+	if err := c.UpdateList(context.Background(), "x", "Go-CFGW Block List - Chunk 1", domains(1)); err == nil {
+		t.Fatalf("update succeeded despite the fault")
+	}
+	if n := srv.Count("PUT", ""); n != 1 {
+		t.Errorf("made %d requests with retries disabled, want 1", n)
+	}
+}
+
+func TestClientReconcilesAmbiguousCreate(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	// Cloudflare creates the list, but the response is a gateway error
+	srv.Inject(cftest.Fault{Method: "POST", Path: "/gateway/lists", Status: http.StatusBadGateway, Commit: true, Times: 1})
+
+	resp, err := c.CreateList(context.Background(), "Go-CFGW Block List - Chunk 1", domains(3))
+	if err != nil {
+		t.Fatalf("create list: %v", err)
+	}
+	lists := srv.Lists()
+	if len(lists) != 1 {
+		t.Fatalf("server has %d lists, want 1 without a duplicate", len(lists))
+	}
+	if id, _ := resp["result"].(map[string]any)["id"].(string); id != lists[0].ID {
+		t.Errorf("returned ID %q, want the existing list %s", id, lists[0].ID)
+	}
+	if n := srv.Count("POST", "/gateway/lists"); n != 1 {
+		t.Errorf("sent %d creates, want 1", n)
+	}
+}
+
+func TestClientReconcilesCancelledCreate(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{WriteLatency: 200 * time.Millisecond})
+	c := newClient(t, srv, nil)
+	// The caller gives up after Cloudflare created the list, but before it answered
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	resp, err := c.CreateList(ctx, "Go-CFGW Block List - Chunk 1", domains(3))
+	if err != nil {
+		t.Fatalf("create list: %v", err)
+	}
+	lists := srv.Lists()
+	if id, _ := resp["result"].(map[string]any)["id"].(string); len(lists) != 1 || id != lists[0].ID {
+		t.Errorf("returned ID %q, want the list Cloudflare created: %+v", id, lists)
+	}
+}
+
+func TestClientLimits(t *testing.T) {
+	ctx := context.Background()
+
+	srv := cftest.NewServer(t, cftest.Options{MaxLists: 1, MaxItemsPerList: 5})
+	c := newClient(t, srv, nil)
+	if got := c.DiscoverLimits(ctx); got.MaxLists != cf.DefaultLimits.MaxLists || got.MaxItemsPerList != cf.DefaultLimits.MaxItemsPerList {
+		t.Errorf("limits without subscription access = %+v, want the defaults", got)
+	}
+
+	_, err := c.CreateList(ctx, "Go-CFGW Block List - Chunk 1", domains(6))
+	var lerr *cf.LimitError
+	if !errors.As(err, &lerr) || lerr.Kind != "items" || lerr.Max != 5 {
+		t.Fatalf("err = %v, want an items LimitError of 5", err)
+	}
+	if _, err := c.CreateList(ctx, "Go-CFGW Block List - Chunk 1", domains(5)); err != nil {
+		t.Fatalf("create list: %v", err)
+	}
+	_, err = c.CreateList(ctx, "Go-CFGW Block List - Chunk 2", domains(5))
+	if !errors.As(err, &lerr) || lerr.Kind != "lists" || lerr.Max != 1 {
+		t.Fatalf("err = %v, want a lists LimitError of 1", err)
+	}
+	if got := c.DiscoverLimits(ctx); got.MaxLists != 1 || got.MaxItemsPerList != 5 {
+		t.Errorf("limits after errors = %+v, want the reported 1 x 5", got)
+	}
+
+	// Higher quotas are not guessed from the plan
+	ent := cftest.NewServer(t, cftest.Options{Plan: "teams_ent"})
+	c = newClient(t, ent, nil)
+	if got := c.DiscoverLimits(ctx); got != cf.DefaultLimits {
+		t.Errorf("limits on an enterprise plan = %+v, want the defaults", got)
+	}
+}
+
+func TestClientForgetsLearnedLimits(t *testing.T) {
+	ctx := context.Background()
+	srv := cftest.NewServer(t, cftest.Options{Plan: "teams_ent"})
+	fresh := time.Now().Add(-time.Hour)
+
+	for _, tc := range []struct {
+		name    string
+		learned cf.Limits
+		want    int // items per list
+	}{
+		{"same plan", cf.Limits{MaxItemsPerList: 3000, Plan: "teams_ent", LearnedAt: fresh}, 3000},
+		{"plan changed", cf.Limits{MaxItemsPerList: 1000, Plan: "teams_standard", LearnedAt: fresh}, cf.DefaultLimits.MaxItemsPerList},
+		{"expired", cf.Limits{MaxItemsPerList: 3000, Plan: "teams_ent", LearnedAt: time.Now().Add(-cf.LearnedLimitsTTL - time.Hour)}, cf.DefaultLimits.MaxItemsPerList},
+		{"no time", cf.Limits{MaxItemsPerList: 3000, Plan: "teams_ent"}, cf.DefaultLimits.MaxItemsPerList},
+	} {
+		c := newClient(t, srv, nil)
+		c.Learn(tc.learned)
+		if got := c.DiscoverLimits(ctx); got.MaxItemsPerList != tc.want {
+			t.Errorf("%s: %d items per list, want %d", tc.name, got.MaxItemsPerList, tc.want)
+		}
+		if l := c.Learned(); tc.want != tc.learned.MaxItemsPerList && l.MaxItemsPerList != 0 {
+			t.Errorf("%s: still remembers %+v", tc.name, l)
+		}
+	}
+}
+
+func TestLease(t *testing.T) {
+	srv := cftest.NewServer(t, cftest.Options{})
+	c := newClient(t, srv, nil)
+	ctx := context.Background()
+
+	a, err := c.AcquireLease(ctx, "a", time.Minute)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	var lerr *cf.LeaseError
+	if _, err := c.AcquireLease(ctx, "b", time.Minute); !errors.As(err, &lerr) || lerr.Holder != "a" {
+		t.Fatalf("second acquire: err = %v, want held by a", err)
+	}
+	if err := a.Renew(ctx); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	b, err := c.AcquireLease(ctx, "b", time.Minute)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	_ = b.Release(ctx)
+}
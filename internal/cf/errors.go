@@ -0,0 +1,93 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APIError is an error response of the Cloudflare API.
+type APIError struct {
+	Status   int
+	Codes    []int    // Cloudflare error codes from the body
+	Messages []string // Cloudflare error messages from the body
+	Body     string
+}
+
+func (e *APIError) Error() string { return fmt.Sprintf("http %d: %s", e.Status, e.Body) }
+
+// Cloudflare error codes that decide whether a request may succeed when retried,
+// whatever the HTTP status says.
+var (
+	transientCodes = map[int]bool{
+		971: true, // please wait and consider throttling your request speed
+	}
+	permanentCodes = map[int]bool{
+		7000:  true, // no route for that URI
+		7003:  true, // could not route, perhaps the object identifier is invalid
+		9106:  true, // missing authentication headers
+		9107:  true,
+		9109:  true, // invalid access token
+		10000: true, // authentication error
+	}
+)
+
+// Temporary reports whether retrying the request may succeed. Rate limiting, request
+// timeouts and server errors are temporary; other client errors, such as invalid input,
+// missing permissions or unknown IDs, are not.
+func (e *APIError) Temporary() bool {
+	for _, c := range e.Codes {
+		if transientCodes[c] {
+			return true
+		}
+		if permanentCodes[c] {
+			return false
+		}
+	}
+	return e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// ambiguous reports whether Cloudflare may have carried out the request despite the
+// error, so that retrying a create could make a duplicate.
+func (e *APIError) ambiguous() bool {
+	return e.Status == http.StatusRequestTimeout || e.Status >= 500
+}
+
+// newAPIError decodes an error response.
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{Status: status, Body: string(body)}
+	var env struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &env) == nil {
+		for _, ae := range env.Errors {
+			e.Codes = append(e.Codes, ae.Code)
+			e.Messages = append(e.Messages, ae.Message)
+		}
+	}
+	return e
+}
+
+// RetryPolicy limits how long a request is retried, by kind of call. Reads are cheap
+// to repeat, while a write that keeps failing is better reported than retried for long.
+// A zero duration disables retries for that kind.
+type RetryPolicy struct {
+	Read   time.Duration // GET
+	Write  time.Duration // POST, PUT and PATCH
+	Delete time.Duration // DELETE
+}
+
+// maxElapsed returns the retry budget of a request with method.
+func (p RetryPolicy) maxElapsed(method string) time.Duration {
+	switch method {
+	case http.MethodGet:
+		return p.Read
+	case http.MethodDelete:
+		return p.Delete
+	}
+	return p.Write
+}
@@ -0,0 +1,173 @@
+package cf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits are the Zero Trust list limits of an account.
+type Limits struct {
+	MaxLists        int    // lists per account
+	MaxItemsPerList int    // items per list
+	Source          string // where the values came from, for logging
+
+	// Limits reported by Cloudflare in errors also record when they were reported and
+	// for which rate plan, so they can be dropped once they may no longer hold.
+	Plan      string
+	LearnedAt time.Time
+}
+
+// LearnedLimitsTTL is how long limits reported by Cloudflare are trusted. A quota that
+// was hit once may since have been raised, so they are rediscovered now and then.
+const LearnedLimitsTTL = 7 * 24 * time.Hour
+
+// MaxItems returns the total number of items the account can hold in lists.
+func (l Limits) MaxItems() int { return l.MaxLists * l.MaxItemsPerList }
+
+// DefaultLimits are the list limits assumed until Cloudflare reports others: 300 lists
+// of 1000 entries, the Free and Standard plan quota the original Node.js scripts were
+// built around. Plans with higher quotas are not guessed from their rate plan IDs, which
+// Cloudflare does not document; their limits come from CLOUDFLARE_MAX_LISTS and
+// CLOUDFLARE_LIST_ITEM_SIZE, or from limits reported in errors.
+var DefaultLimits = Limits{MaxLists: 300, MaxItemsPerList: 1000, Source: "default"}
+
+// LimitError is returned when Cloudflare rejects a request because a list limit was hit.
+type LimitError struct {
+	Kind    string // "items" or "lists"
+	Max     int    // limit reported by Cloudflare, 0 if the message had no number
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("cloudflare %s limit reached: %s", e.Kind, e.Message)
+}
+
+var (
+	itemLimitPattern = regexp.MustCompile(`(?i)(?:max(?:imum)?|limit)[^0-9]{0,20}(\d+)\s*(?:items|entries)|(\d+)\s*(?:items|entries)[^.]{0,20}(?:max(?:imum)?|limit)`)
+	listLimitPattern = regexp.MustCompile(`(?i)(?:max(?:imum)?|limit)[^0-9]{0,20}(\d+)\s*lists|(\d+)\s*lists[^.]{0,20}(?:max(?:imum)?|limit)`)
+)
+
+// learnLimit inspects an error response for list limit violations. It records the
+// reported limit and returns a *LimitError, or nil if aerr is not a limit error.
+func (c *Client) learnLimit(aerr *APIError) error {
+	for _, msg := range aerr.Messages {
+		for _, p := range []struct {
+			kind string
+			re   *regexp.Regexp
+		}{{"items", itemLimitPattern}, {"lists", listLimitPattern}} {
+			m := p.re.FindStringSubmatch(msg)
+			if m == nil {
+				continue
+			}
+			n, _ := strconv.Atoi(m[1] + m[2])
+			c.mu.Lock()
+			if n > 0 {
+				if p.kind == "items" {
+					c.learned.MaxItemsPerList = n
+				} else {
+					c.learned.MaxLists = n
+				}
+				c.learned.Plan = c.plan
+				c.learned.LearnedAt = time.Now().UTC()
+			}
+			c.mu.Unlock()
+			c.logger.Warnf("Cloudflare reported a %s limit: %s", p.kind, msg)
+			return &LimitError{Kind: p.kind, Max: n, Message: msg}
+		}
+	}
+	return nil
+}
+
+// Learned returns the limits Cloudflare reported in errors so far, so they can be
+// remembered across processes. Zero values were never reported.
+func (c *Client) Learned() Limits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.learned
+}
+
+// Learn records limits reported to an earlier client, typically loaded from the state
+// file. Limits reported to this client take precedence. Limits older than
+// LearnedLimitsTTL, or without a time, are ignored.
+func (c *Client) Learn(l Limits) {
+	if l.LearnedAt.IsZero() || time.Since(l.LearnedAt) > LearnedLimitsTTL {
+		c.logger.Debugf("forgetting list limits reported by Cloudflare on %v", l.LearnedAt.Format(time.DateOnly))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learned.MaxLists > 0 || c.learned.MaxItemsPerList > 0 {
+		return
+	}
+	c.learned = l
+}
+
+// DiscoverLimits infers the account's list limits. It starts from the defaults and
+// applies limits Cloudflare reported in errors, as long as the account's Zero Trust
+// subscription is still on the plan they were reported for. Discovery never fails;
+// missing permissions simply leave the defaults and any reported limits in place.
+func (c *Client) DiscoverLimits(ctx context.Context) Limits {
+	l := DefaultLimits
+	plan, err := c.zeroTrustPlan(ctx)
+	if err != nil {
+		c.logger.Debugf("could not read subscriptions: %v", err)
+	} else if plan != "" {
+		c.logger.Debugf("Zero Trust rate plan: %s", plan)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learned.MaxLists > 0 || c.learned.MaxItemsPerList > 0 {
+		// A long-running client may hold limits that expired since they were reported
+		switch {
+		case time.Since(c.learned.LearnedAt) > LearnedLimitsTTL:
+			c.logger.Debugf("forgetting list limits reported by Cloudflare on %v", c.learned.LearnedAt.Format(time.DateOnly))
+			c.learned = Limits{}
+		case err == nil && c.learned.Plan != plan:
+			c.logger.Infof("Zero Trust plan changed from %q to %q, forgetting list limits reported by Cloudflare", c.learned.Plan, plan)
+			c.learned = Limits{}
+		}
+	}
+	if err == nil {
+		c.plan = plan
+	}
+	if c.learned.MaxLists > 0 {
+		l.MaxLists = c.learned.MaxLists
+		l.Source = "reported by cloudflare"
+	}
+	if c.learned.MaxItemsPerList > 0 {
+		l.MaxItemsPerList = c.learned.MaxItemsPerList
+		l.Source = "reported by cloudflare"
+	}
+	return l
+}
+
+// zeroTrustPlan returns the rate plan ID of the account's Zero Trust subscription.
+func (c *Client) zeroTrustPlan(ctx context.Context) (string, error) {
+	b, err := c.doAccountRequest(ctx, "GET", "/subscriptions", nil, false)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Result []struct {
+			RatePlan struct {
+				ID string `json:"id"`
+			} `json:"rate_plan"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", err
+	}
+	for _, s := range resp.Result {
+		id := strings.ToLower(s.RatePlan.ID)
+		if strings.Contains(id, "teams") || strings.Contains(id, "zero_trust") {
+			return id, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,155 @@
+package cf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LockListName is the name of the empty list that marks a sync in progress.
+const LockListName = "Go-CFGW Lock"
+
+// LeaseError is returned when another run holds the remote lock.
+type LeaseError struct {
+	Holder  string
+	Expires time.Time
+}
+
+func (e *LeaseError) Error() string {
+	return fmt.Sprintf("remote lock held by %s until %s", e.Holder, e.Expires.Format(time.RFC3339))
+}
+
+// leaseInfo is stored as JSON in the lock list's description.
+type leaseInfo struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lease is a held remote lock. It expires after its TTL unless renewed, so a crashed
+// run blocks others for at most one TTL.
+type Lease struct {
+	c      *Client
+	id     string
+	holder string
+	ttl    time.Duration
+}
+
+// AcquireLease takes the remote lock: an empty list named LockListName whose
+// description holds the holder and an expiry. Expired leases are taken over. When two
+// runs create a lock list at the same moment, the one created first wins (lowest ID on a
+// tie) and the other backs off. It returns a *LeaseError if another run holds the lock.
+func (c *Client) AcquireLease(ctx context.Context, holder string, ttl time.Duration) (*Lease, error) {
+	if err := c.clearExpiredLeases(ctx); err != nil {
+		return nil, err
+	}
+
+	l := &Lease{c: c, holder: holder, ttl: ttl}
+	body := map[string]any{"name": LockListName, "type": "DOMAIN", "description": l.description(), "items": []any{}}
+	b, err := c.doCreate(ctx, "/lists", body, func(ctx context.Context) ([]byte, bool, error) {
+		leases, err := c.leases(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, ls := range leases {
+			if ls.info.Holder == holder {
+				b, err := json.Marshal(map[string]any{"success": true, "result": map[string]any{"id": ls.id}})
+				return b, true, err
+			}
+		}
+		return nil, false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create lock list: %w", err)
+	}
+	var resp struct {
+		Result struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil || resp.Result.ID == "" {
+		return nil, fmt.Errorf("create lock list: no ID in response")
+	}
+	l.id = resp.Result.ID
+
+	// Settle races between runs that saw no lock at the same time
+	leases, err := c.leases(ctx)
+	if err != nil {
+		_ = l.Release(ctx)
+		return nil, err
+	}
+	if len(leases) == 0 {
+		return nil, fmt.Errorf("lock list %s disappeared right after creation", l.id)
+	}
+	if winner := leases[0]; winner.id != l.id {
+		_ = l.Release(ctx)
+		return nil, &LeaseError{Holder: winner.info.Holder, Expires: winner.info.Expires}
+	}
+	return l, nil
+}
+
+// Renew extends the lease by its TTL.
+func (l *Lease) Renew(ctx context.Context) error {
+	body := map[string]any{"name": LockListName, "description": l.description()}
+	_, err := l.c.doRequestWithRetry(ctx, "PUT", "/lists/"+l.id, body)
+	return err
+}
+
+// Release deletes the lock list.
+func (l *Lease) Release(ctx context.Context) error {
+	return l.c.DeleteList(ctx, l.id)
+}
+
+func (l *Lease) description() string {
+	b, _ := json.Marshal(leaseInfo{Holder: l.holder, Expires: time.Now().Add(l.ttl).UTC()})
+	return string(b)
+}
+
+type lease struct {
+	id      string
+	created time.Time
+	info    leaseInfo
+}
+
+// leases returns the current lock lists, oldest first.
+func (c *Client) leases(ctx context.Context) ([]lease, error) {
+	lists, err := c.Lists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get lists: %w", err)
+	}
+	var out []lease
+	for _, l := range lists {
+		if l.Name != LockListName {
+			continue
+		}
+		var info leaseInfo
+		_ = json.Unmarshal([]byte(l.Description), &info)
+		out = append(out, lease{id: l.ID, created: l.CreatedAt, info: info})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].created.Equal(out[j].created) {
+			return out[i].created.Before(out[j].created)
+		}
+		return out[i].id < out[j].id
+	})
+	return out, nil
+}
+
+// clearExpiredLeases deletes expired lock lists and reports a live one as a *LeaseError.
+func (c *Client) clearExpiredLeases(ctx context.Context) error {
+	leases, err := c.leases(ctx)
+	if err != nil {
+		return err
+	}
+	for _, l := range leases {
+		if time.Now().Before(l.info.Expires) {
+			return &LeaseError{Holder: l.info.Holder, Expires: l.info.Expires}
+		}
+		c.logger.Warnf("Taking over expired remote lock held by %q", l.info.Holder)
+		if err := c.DeleteList(ctx, l.id); err != nil {
+			return fmt.Errorf("delete expired lock list: %w", err)
+		}
+	}
+	return nil
+}
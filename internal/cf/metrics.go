@@ -0,0 +1,102 @@
+package cf
+
+import (
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/metrics"
+)
+
+var (
+	apiRequests = metrics.NewCounter("gocfgw_cloudflare_requests_total",
+		"Cloudflare API requests by method and HTTP status (\"error\" when no response was received).", "method", "status")
+	apiRateLimited = metrics.NewCounter("gocfgw_cloudflare_rate_limited_total",
+		"Cloudflare API responses with status 429.")
+	apiBackoff = metrics.NewCounter("gocfgw_cloudflare_backoff_seconds_total",
+		"Time spent waiting before retrying Cloudflare API requests, including Retry-After delays.")
+	apiThrottle = metrics.NewCounter("gocfgw_cloudflare_throttle_seconds_total",
+		"Time Cloudflare API requests waited for the client-side rate limiter.")
+)
+
+// APIStats counts the API requests a Client made. Unlike the metrics, which add up
+// over the process lifetime, snapshots can be subtracted to describe a single sync.
+type APIStats struct {
+	Requests    int
+	ByMethod    map[string]int
+	ByStatus    map[string]int // HTTP status, or "error" when no response arrived
+	RateLimited int
+	Backoff     time.Duration // time spent waiting before retries
+	Throttled   time.Duration // time spent waiting for the rate limiter
+}
+
+// Stats returns a snapshot of the client's request counts.
+func (c *Client) Stats() APIStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := c.stats
+	out.ByMethod, out.ByStatus = copyCounts(c.stats.ByMethod), copyCounts(c.stats.ByStatus)
+	return out
+}
+
+// Sub returns the requests made since the earlier snapshot before.
+func (s APIStats) Sub(before APIStats) APIStats {
+	out := APIStats{
+		Requests:    s.Requests - before.Requests,
+		RateLimited: s.RateLimited - before.RateLimited,
+		Backoff:     s.Backoff - before.Backoff,
+		Throttled:   s.Throttled - before.Throttled,
+		ByMethod:    map[string]int{},
+		ByStatus:    map[string]int{},
+	}
+	for k, v := range s.ByMethod {
+		if d := v - before.ByMethod[k]; d > 0 {
+			out.ByMethod[k] = d
+		}
+	}
+	for k, v := range s.ByStatus {
+		if d := v - before.ByStatus[k]; d > 0 {
+			out.ByStatus[k] = d
+		}
+	}
+	return out
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	out := make(map[string]int, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// countRequest records a request in the metrics and the client's stats.
+func (c *Client) countRequest(method, status string) {
+	apiRequests.Inc(method, status)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats.ByMethod == nil {
+		c.stats.ByMethod, c.stats.ByStatus = map[string]int{}, map[string]int{}
+	}
+	c.stats.Requests++
+	c.stats.ByMethod[method]++
+	c.stats.ByStatus[status]++
+	if status == "429" {
+		apiRateLimited.Inc()
+		c.stats.RateLimited++
+	}
+}
+
+// countBackoff records time spent waiting before a retry.
+func (c *Client) countBackoff(d time.Duration) {
+	apiBackoff.Add(d.Seconds())
+	c.mu.Lock()
+	c.stats.Backoff += d
+	c.mu.Unlock()
+}
+
+// countThrottle records time a request waited for the rate limiter.
+func (c *Client) countThrottle(d time.Duration) {
+	apiThrottle.Add(d.Seconds())
+	c.mu.Lock()
+	c.stats.Throttled += d
+	c.mu.Unlock()
+}
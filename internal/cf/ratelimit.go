@@ -0,0 +1,216 @@
+package cf
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+)
+
+// Limiter is a token bucket that spaces out requests to the Cloudflare API before
+// Cloudflare has to reject them. It also holds every request back while Cloudflare
+// asks clients to pause, through Retry-After or a rate limit header reporting no
+// remaining requests. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, 0 for no client-side limit
+	burst  float64
+	tokens float64
+	last   time.Time
+	paused time.Time // no request is sent before this time
+}
+
+// NewLimiter returns a limiter allowing rate requests per second on average and up
+// to burst at once. A rate of 0 only honours pauses requested by Cloudflare.
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{}
+	l.setRate(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+func (l *Limiter) setRate(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	l.rate, l.burst = rate, float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mu.Unlock()
+}
+
+// limiters holds one limiter per API host and credential, as Cloudflare counts
+// requests per user. Clients created for the same account, such as the remote lock's,
+// share it.
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*Limiter{}
+)
+
+// sharedLimiter returns the limiter for key, updating its rate to the current
+// configuration.
+func sharedLimiter(key string, rate float64, burst int) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	l, ok := limiters[key]
+	if !ok {
+		l = NewLimiter(rate, burst)
+		limiters[key] = l
+		return l
+	}
+	l.setRate(rate, burst)
+	return l
+}
+
+// Wait blocks until a request may be sent and returns how long it waited.
+func (l *Limiter) Wait(ctx context.Context) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		d := l.reserve(time.Now())
+		if d <= 0 {
+			return waited, nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return waited, ctx.Err()
+		case <-t.C:
+			waited += d
+		}
+	}
+}
+
+// reserve takes a token and returns 0, or returns how long to wait before trying
+// again.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.paused) {
+		return l.paused.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Pause holds every request back until t. The bucket then restarts with a single
+// token, so requests resume at the configured rate instead of in a burst.
+func (l *Limiter) Pause(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.paused) {
+		l.paused = t
+		l.tokens, l.last = 1, t
+	}
+}
+
+// retryAfter parses a Retry-After header, in delay-seconds or HTTP-date form.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// rateLimitReset returns how long until the rate limit window resets when a response
+// reports that no requests remain in it. Cloudflare sends the structured form of the
+// IETF draft, `Ratelimit: "default";r=0;t=30`; the older RateLimit-Remaining and
+// RateLimit-Reset pair, also with an X- prefix, is understood as well.
+func rateLimitReset(h http.Header) (time.Duration, bool) {
+	remaining, reset := -1, -1
+	if v := h.Get("Ratelimit"); v != "" && !strings.Contains(v, "remaining") {
+		for _, param := range strings.Split(v, ";") {
+			k, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil {
+				continue
+			}
+			switch k {
+			case "r":
+				remaining = n
+			case "t":
+				reset = n
+			}
+		}
+	} else if v != "" {
+		// Earlier draft: "limit=1200, remaining=0, reset=30"
+		for _, param := range strings.Split(v, ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			n, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil {
+				continue
+			}
+			switch k {
+			case "remaining":
+				remaining = n
+			case "reset":
+				reset = n
+			}
+		}
+	}
+	for _, prefix := range []string{"Ratelimit-", "X-Ratelimit-"} {
+		if n, err := strconv.Atoi(strings.TrimSpace(h.Get(prefix + "Remaining"))); err == nil && remaining < 0 {
+			remaining = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(h.Get(prefix + "Reset"))); err == nil && reset < 0 {
+			reset = n
+		}
+	}
+	if remaining != 0 || reset <= 0 {
+		return 0, false
+	}
+	return time.Duration(reset) * time.Second, true
+}
+
+// hintBackOff waits as long as the server asked, through Retry-After, before the next
+// attempt, instead of adding exponential backoff on top of it. The wrapped policy
+// still decides when to give up.
+type hintBackOff struct {
+	backoff.BackOff
+	hint time.Duration
+}
+
+func (b *hintBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop || b.hint <= 0 {
+		return next
+	}
+	next, b.hint = b.hint, 0
+	return next
+}
@@ -0,0 +1,629 @@
+// Package cftest runs a fake Cloudflare Gateway API for tests. It keeps Zero Trust
+// lists and Gateway rules in memory, enforces the account's list limits and can be told
+// to answer slowly, rate limit or fail, so the client and the worker can be exercised
+// end to end without a live account.
+package cftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Options configure a Server.
+type Options struct {
+	AccountID string // account the API serves, "test-account" if unset
+	Token     string // API token the server accepts, "test-token" if unset
+	// List limits of the account; 0 for the documented defaults of 300 lists of
+	// 1000 items each.
+	MaxLists        int
+	MaxItemsPerList int
+	// Plan is the rate plan ID of the account's Zero Trust subscription, such as
+	// "teams_ent". Without one, reading subscriptions fails with 403 like it does for a
+	// token without billing permissions.
+	Plan string
+	// Latency delays every response. A request the client gives up on in the meantime
+	// is dropped, like a connection lost before it reached Cloudflare.
+	Latency time.Duration
+	// WriteLatency delays successful responses to writes after they were carried out,
+	// so a client giving up in the meantime leaves the change made.
+	WriteLatency time.Duration
+}
+
+// Server is a fake Cloudflare API for a single account. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+	opts Options
+
+	mu       sync.Mutex
+	nextID   int
+	lists    map[string]*List
+	rules    map[string]*Rule
+	faults   []*fault
+	requests []Request
+	inFlight int
+	peak     int
+}
+
+// List is a Zero Trust list held by the server.
+type List struct {
+	ID          string
+	Name        string
+	Description string
+	Type        string
+	Items       []string
+	CreatedAt   time.Time
+}
+
+// Rule is a Gateway rule held by the server.
+type Rule struct {
+	ID               string
+	Name             string
+	Description      string
+	Action           string
+	Enabled          bool
+	Filters          []string
+	Traffic          string
+	Precedence       int
+	BlockPageEnabled bool
+}
+
+// Request is a request the server answered.
+type Request struct {
+	Method string
+	Path   string // below /accounts/{id}, without the query
+	Name   string // "name" of the JSON request body, if any
+	Status int
+}
+
+// Fault makes matching requests fail. Empty fields match any request.
+type Fault struct {
+	Method string // e.g. "POST"
+	Path   string // prefix of the path below /accounts/{id}, e.g. "/gateway/lists"
+	Name   string // "name" of the JSON request body, e.g. to fail a single list
+	Times  int    // requests to fail before the fault clears itself; 0 fails all
+
+	Status     int    // HTTP status of the response, 500 if unset
+	Code       int    // Cloudflare error code in the body
+	Message    string // Cloudflare error message in the body, the status text if unset
+	RetryAfter string // Retry-After header, e.g. "1"
+	// Commit carries the request out before failing it, like a response lost after
+	// Cloudflare made the change.
+	Commit bool
+}
+
+type fault struct {
+	Fault
+	left int
+}
+
+// RateLimited returns a fault answering the next times requests with 429 and a
+// Retry-After of one second, as Cloudflare does when the account's rate limit is used up.
+func RateLimited(times int) Fault {
+	return Fault{Status: http.StatusTooManyRequests, Code: 971, Message: "Please wait and consider throttling your request speed", RetryAfter: "1", Times: times}
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+	if opts.AccountID == "" {
+		opts.AccountID = "test-account"
+	}
+	if opts.Token == "" {
+		opts.Token = "test-token"
+	}
+	if opts.MaxLists <= 0 {
+		opts.MaxLists = 300
+	}
+	if opts.MaxItemsPerList <= 0 {
+		opts.MaxItemsPerList = 1000
+	}
+	s := &Server{opts: opts, lists: map[string]*List{}, rules: map[string]*Rule{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Setenv points config.LoadFromEnv at the server for the rest of the test.
+func (s *Server) Setenv(t testing.TB) {
+	t.Helper()
+	t.Setenv("CLOUDFLARE_API_HOST", s.URL)
+	t.Setenv("CLOUDFLARE_API_TOKEN", s.opts.Token)
+	t.Setenv("CLOUDFLARE_ACCOUNT_ID", s.opts.AccountID)
+}
+
+// Inject adds a fault. Faults are matched in the order they were added.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, left: f.Times})
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the requests answered so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count returns the number of requests answered with method ("" for any) whose path
+// starts with prefix.
+func (s *Server) Count(method, prefix string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if (method == "" || r.Method == method) && strings.HasPrefix(r.Path, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// PeakInFlight returns the largest number of requests that were handled at once.
+func (s *Server) PeakInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+// Lists returns the account's lists, oldest first.
+func (s *Server) Lists() []List {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]List, 0, len(s.lists))
+	for _, l := range s.sortedLists() {
+		c := *l
+		c.Items = append([]string(nil), l.Items...)
+		out = append(out, c)
+	}
+	return out
+}
+
+// Rules returns the account's rules, oldest first.
+func (s *Server) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Rule, 0, len(s.rules))
+	for _, r := range s.sortedRules() {
+		c := *r
+		c.Filters = append([]string(nil), r.Filters...)
+		out = append(out, c)
+	}
+	return out
+}
+
+// AddList creates a list directly, as if made in the dashboard or by another tool.
+func (s *Server) AddList(name string, items ...string) List {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := &List{ID: s.newID(), Name: name, Type: "DOMAIN", Items: append([]string(nil), items...), CreatedAt: time.Now().UTC()}
+	s.lists[l.ID] = l
+	return *l
+}
+
+// AddRule creates an enabled block rule directly, as if made in the dashboard.
+func (s *Server) AddRule(name, traffic string) Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Rule{ID: s.newID(), Name: name, Action: "block", Enabled: true, Filters: []string{"dns"}, Traffic: traffic, Precedence: s.nextPrecedence()}
+	s.rules[r.ID] = r
+	return *r
+}
+
+// RemoveList deletes a list directly, even one a rule references, as if it was removed
+// behind the back of go-cfgw.
+func (s *Server) RemoveList(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lists, id)
+}
+
+// response is an answer of the API.
+type response struct {
+	status     int
+	result     any
+	resultInfo map[string]int
+	code       int
+	message    string
+	retryAfter string
+}
+
+func ok(result any) response { return response{status: http.StatusOK, result: result} }
+
+func apiError(status, code int, format string, args ...any) response {
+	return response{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.peak {
+		s.peak = s.inFlight
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	if s.opts.Latency > 0 {
+		t := time.NewTimer(s.opts.Latency)
+		select {
+		case <-r.Context().Done():
+			// The client gave up; like a dropped connection, nothing is changed
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	var body map[string]any
+	raw, _ := io.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, "/accounts/"+s.opts.AccountID)
+	resp := response{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &body); err != nil {
+			resp = apiError(http.StatusBadRequest, 6007, "Malformed JSON in request body")
+		}
+	}
+	name, _ := body["name"].(string)
+
+	s.mu.Lock()
+	switch {
+	case resp.status != 0:
+	case r.Header.Get("Authorization") != "Bearer "+s.opts.Token:
+		resp = apiError(http.StatusForbidden, 10000, "Authentication error")
+	case path == r.URL.Path || !strings.HasPrefix(path, "/"):
+		resp = apiError(http.StatusNotFound, 7003, "Could not route to %s, perhaps your object identifier is invalid?", r.URL.Path)
+	default:
+		f := s.fault(r.Method, path, name)
+		if f == nil || f.Commit {
+			resp = s.route(r.Method, path, r.URL.Query(), body)
+		}
+		if f != nil {
+			resp = f.response()
+		}
+	}
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Name: name, Status: resp.status})
+	ray := len(s.requests)
+	s.mu.Unlock()
+
+	if s.opts.WriteLatency > 0 && r.Method != "GET" && resp.status < 300 {
+		t := time.NewTimer(s.opts.WriteLatency)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	env := map[string]any{"success": resp.status < 300, "errors": []any{}, "messages": []any{}, "result": resp.result}
+	if resp.status >= 300 {
+		env["errors"] = []any{map[string]any{"code": resp.code, "message": resp.message}}
+	}
+	if resp.resultInfo != nil {
+		env["result_info"] = resp.resultInfo
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cf-Ray", fmt.Sprintf("%016x-TST", ray))
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.WriteHeader(resp.status)
+	_ = json.NewEncoder(w).Encode(env)
+}
+
+// fault returns the first fault matching the request and uses it up once.
+func (s *Server) fault(method, path, name string) *fault {
+	for i, f := range s.faults {
+		if (f.Method != "" && f.Method != method) || !strings.HasPrefix(path, f.Path) || (f.Name != "" && f.Name != name) {
+			continue
+		}
+		if f.Times > 0 {
+			if f.left--; f.left == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (f *fault) response() response {
+	status := f.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	msg := f.Message
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	return response{status: status, code: f.Code, message: msg, retryAfter: f.RetryAfter}
+}
+
+// route carries out a request. The caller holds s.mu.
+func (s *Server) route(method, path string, query map[string][]string, body map[string]any) response {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	id := ""
+	if len(parts) >= 3 && parts[0] == "gateway" {
+		id, parts[2] = parts[2], "{id}"
+	}
+	switch method + " /" + strings.Join(parts, "/") {
+	case "GET /subscriptions":
+		if s.opts.Plan == "" {
+			return apiError(http.StatusForbidden, 10000, "Authentication error")
+		}
+		return ok([]any{map[string]any{"id": "subscription", "rate_plan": map[string]any{"id": s.opts.Plan}}})
+
+	case "GET /gateway/lists":
+		out := []any{}
+		for _, l := range s.sortedLists() {
+			out = append(out, l.json())
+		}
+		return ok(out)
+	case "POST /gateway/lists":
+		return s.createList(body)
+	case "GET /gateway/lists/{id}":
+		if l, found := s.lists[id]; found {
+			return ok(l.json())
+		}
+	case "PUT /gateway/lists/{id}":
+		return s.updateList(id, body)
+	case "DELETE /gateway/lists/{id}":
+		return s.deleteList(id)
+	case "GET /gateway/lists/{id}/items":
+		return s.listItems(id, query)
+
+	case "GET /gateway/rules":
+		out := []any{}
+		for _, r := range s.sortedRules() {
+			out = append(out, r.json())
+		}
+		return ok(out)
+	case "POST /gateway/rules":
+		return s.writeRule(&Rule{}, body)
+	case "PUT /gateway/rules/{id}":
+		if r, found := s.rules[id]; found {
+			return s.writeRule(r, body)
+		}
+	case "DELETE /gateway/rules/{id}":
+		if _, found := s.rules[id]; found {
+			delete(s.rules, id)
+			return ok(map[string]any{"id": id})
+		}
+	default:
+		return apiError(http.StatusNotFound, 7000, "No route for that URI")
+	}
+	return apiError(http.StatusNotFound, 7003, "Could not route to %s, perhaps your object identifier is invalid?", path)
+}
+
+func (s *Server) createList(body map[string]any) response {
+	name, _ := body["name"].(string)
+	if name == "" {
+		return apiError(http.StatusBadRequest, 2001, "name is required")
+	}
+	if len(s.lists) >= s.opts.MaxLists {
+		return apiError(http.StatusBadRequest, 2030, "account has reached the maximum of %d lists", s.opts.MaxLists)
+	}
+	items, res := s.items(body)
+	if res.status != 0 {
+		return res
+	}
+	typ, _ := body["type"].(string)
+	description, _ := body["description"].(string)
+	l := &List{ID: s.newID(), Name: name, Description: description, Type: typ, Items: items, CreatedAt: time.Now().UTC()}
+	s.lists[l.ID] = l
+	return ok(l.json())
+}
+
+func (s *Server) updateList(id string, body map[string]any) response {
+	l, found := s.lists[id]
+	if !found {
+		return apiError(http.StatusNotFound, 7003, "Could not route to /gateway/lists/%s, perhaps your object identifier is invalid?", id)
+	}
+	name, _ := body["name"].(string)
+	if name == "" {
+		return apiError(http.StatusBadRequest, 2001, "name is required")
+	}
+	if _, replace := body["items"]; replace {
+		items, res := s.items(body)
+		if res.status != 0 {
+			return res
+		}
+		l.Items = items
+	}
+	l.Name = name
+	if d, set := body["description"].(string); set {
+		l.Description = d
+	}
+	return ok(l.json())
+}
+
+// items decodes and checks the items of a list write.
+func (s *Server) items(body map[string]any) ([]string, response) {
+	raw, _ := body["items"].([]any)
+	if len(raw) > s.opts.MaxItemsPerList {
+		return nil, apiError(http.StatusBadRequest, 2030, "list exceeds the maximum of %d items", s.opts.MaxItemsPerList)
+	}
+	items := make([]string, 0, len(raw))
+	for _, it := range raw {
+		m, _ := it.(map[string]any)
+		v, _ := m["value"].(string)
+		if v == "" {
+			return nil, apiError(http.StatusBadRequest, 2001, "list items need a value")
+		}
+		items = append(items, v)
+	}
+	return items, response{}
+}
+
+func (s *Server) deleteList(id string) response {
+	if _, found := s.lists[id]; !found {
+		return apiError(http.StatusNotFound, 7003, "Could not route to /gateway/lists/%s, perhaps your object identifier is invalid?", id)
+	}
+	// Like Cloudflare, refuse to delete a list that a rule still uses
+	for _, r := range s.sortedRules() {
+		for _, ref := range listRefs(r.Traffic) {
+			if ref == id {
+				return apiError(http.StatusBadRequest, 2033, "list is referenced by rule %q", r.Name)
+			}
+		}
+	}
+	delete(s.lists, id)
+	return ok(map[string]any{"id": id})
+}
+
+// listItems returns a page of a list's items.
+func (s *Server) listItems(id string, query map[string][]string) response {
+	l, found := s.lists[id]
+	if !found {
+		return apiError(http.StatusNotFound, 7003, "Could not route to /gateway/lists/%s/items, perhaps your object identifier is invalid?", id)
+	}
+	page, perPage := queryInt(query, "page", 1), queryInt(query, "per_page", 50)
+	if page < 1 || perPage < 1 || perPage > 1000 {
+		return apiError(http.StatusBadRequest, 2001, "invalid pagination")
+	}
+	start, end := (page-1)*perPage, page*perPage
+	if start > len(l.Items) {
+		start = len(l.Items)
+	}
+	if end > len(l.Items) {
+		end = len(l.Items)
+	}
+	items := []any{}
+	for _, v := range l.Items[start:end] {
+		items = append(items, map[string]any{"value": v, "created_at": l.CreatedAt.Format(time.RFC3339)})
+	}
+	// Cloudflare wraps the page in an extra array
+	res := ok([]any{items})
+	res.resultInfo = map[string]int{"page": page, "per_page": perPage, "count": end - start, "total_count": len(l.Items)}
+	return res
+}
+
+// writeRule creates r, if it has no ID yet, or updates it from a request body.
+func (s *Server) writeRule(r *Rule, body map[string]any) response {
+	name, _ := body["name"].(string)
+	if name == "" {
+		return apiError(http.StatusBadRequest, 2001, "name is required")
+	}
+	traffic, _ := body["traffic"].(string)
+	for _, ref := range listRefs(traffic) {
+		if _, found := s.lists[ref]; !found {
+			return apiError(http.StatusBadRequest, 2001, "traffic references unknown list %s", ref)
+		}
+	}
+	next := *r
+	next.Name, next.Traffic = name, traffic
+	next.Description, _ = body["description"].(string)
+	next.Action, _ = body["action"].(string)
+	next.Enabled, _ = body["enabled"].(bool)
+	next.Filters = nil
+	if filters, _ := body["filters"].([]any); filters != nil {
+		for _, f := range filters {
+			if f, _ := f.(string); f != "" {
+				next.Filters = append(next.Filters, f)
+			}
+		}
+	}
+	if settings, _ := body["rule_settings"].(map[string]any); settings != nil {
+		next.BlockPageEnabled, _ = settings["block_page_enabled"].(bool)
+	}
+	if p, set := body["precedence"].(float64); set && p > 0 {
+		next.Precedence = int(p)
+	} else if next.Precedence == 0 {
+		next.Precedence = s.nextPrecedence()
+	}
+	if next.ID == "" {
+		next.ID = s.newID()
+	}
+	*r = next
+	s.rules[r.ID] = r
+	return ok(r.json())
+}
+
+func (l *List) json() map[string]any {
+	return map[string]any{
+		"id": l.ID, "name": l.Name, "description": l.Description, "type": l.Type, "count": len(l.Items),
+		"created_at": l.CreatedAt.Format(time.RFC3339), "updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func (r *Rule) json() map[string]any {
+	filters := append([]string{}, r.Filters...)
+	return map[string]any{
+		"id": r.ID, "name": r.Name, "description": r.Description, "action": r.Action, "enabled": r.Enabled,
+		"filters": filters, "traffic": r.Traffic, "precedence": r.Precedence,
+		"rule_settings": map[string]any{"block_page_enabled": r.BlockPageEnabled},
+	}
+}
+
+// newID returns a UUID-shaped ID. IDs sort in creation order.
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.nextID)
+}
+
+func (s *Server) nextPrecedence() int {
+	p := 0
+	for _, r := range s.rules {
+		if r.Precedence > p {
+			p = r.Precedence
+		}
+	}
+	return p + 1000
+}
+
+func (s *Server) sortedLists() []*List {
+	out := make([]*List, 0, len(s.lists))
+	for _, l := range s.lists {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *Server) sortedRules() []*Rule {
+	out := make([]*Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+var listRefPattern = regexp.MustCompile(`\$([0-9a-fA-F-]{36})`)
+
+// listRefs returns the IDs of the lists a traffic expression uses, written as $id.
+func listRefs(traffic string) []string {
+	var out []string
+	for _, m := range listRefPattern.FindAllStringSubmatch(traffic, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+func queryInt(query map[string][]string, key string, def int) int {
+	if v := query[key]; len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil {
+			return n
+		}
+	}
+	return def
+}
@@ -2,9 +2,15 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,20 +22,65 @@ type Config struct {
 	AccountID        string
 	AccountEmail     string
 	APIHost          string
+	APIRateLimit     float64       // client-side limit in requests per second, 0 to rely on Cloudflare's responses alone
+	APIRateBurst     int           // requests that may be sent at once before the rate limit applies (default 10)
+	RetryRead        time.Duration // how long failing GET requests are retried (default 2m, 0 disables retries)
+	RetryWrite       time.Duration // how long failing POST and PUT requests are retried (default 2m)
+	RetryDelete      time.Duration // how long failing DELETE requests are retried (default 2m)
 	AllowURLs        []string
 	BlockURLs        []string
-	ListItemLimit    int // total limit across all lists (default 300000)
-	ListItemSize     int // chunk size per list (hardcoded 1000, Cloudflare's limit)
+	ListItemLimit    int // total limit across all lists, 0 to derive it from the account's limits
+	ListItemSize     int // chunk size per list, 0 to discover it from the account (1000 on free plans)
+	MaxLists         int // lists per account, 0 to discover it from the account (300 on free plans)
+	ChunkConcurrency int // lists created or updated at the same time (default 4)
 	DryRun           bool
 	BlockPageEnabled bool
 	BlockBasedOnSNI  bool
 	DiscordWebhook   string
+	WildcardPolicy   string                   // "suffix" (default) or "reject" for "*.example.com" entries
+	StrictSources    bool                     // fail the run when a source exceeds MaxRejectPercent
+	MaxRejectPercent float64                  // per-source rejected line percentage before warning/failing (default 50)
+	CacheDir         string                   // on-disk source cache, empty when disabled (default .go-cfgw/cache)
+	CacheMaxAge      time.Duration            // max age of a cached copy used when a source is down (default 24h)
+	DownloadWorkers  int                      // sources downloaded concurrently (default 4)
+	PerHostWorkers   int                      // concurrent requests per source host (default 1)
+	PerHostInterval  time.Duration            // minimum delay between requests to one host (default 500ms)
+	ExtraAllow       []string                 // inline allowlist entries from EXTRA_ALLOW_DOMAINS and CONFIG_FILE
+	ExtraBlock       []string                 // inline blocklist entries from EXTRA_BLOCK_DOMAINS and CONFIG_FILE
+	GuardAction      string                   // "skip" (default) or "abort" when a source trips a sanity guard
+	GuardRebaseline  int                      // runs in a row a source may trip the change guard before its new size is accepted, 0 for never
+	HistoryFile      string                   // per-source entry counts of the previous run (default .go-cfgw/sources.json)
+	IndexFile        string                   // provenance index of the last run (default .go-cfgw/index.json)
+	StateFile        string                   // IDs and hashes of managed Cloudflare resources (default .go-cfgw/state.json)
+	DriftCheck       string                   // "off", "quick" (default) or "full" drift check at the start of a sync
+	DriftAction      string                   // "repair" (default) or "abort" when drift is found
+	LockFile         string                   // local lock file held during a sync (default .go-cfgw/sync.lock)
+	LockWait         time.Duration            // how long to wait for a running sync before giving up (default 0, exit at once)
+	RemoteLock       bool                     // also take a lease on Cloudflare, for runs on different machines
+	RemoteLockTTL    time.Duration            // lifetime of the remote lease, renewed while the sync runs (default 30m)
+	Schedule         string                   // daemon schedule: interval or cron expression (default 1h)
+	ScheduleJitter   time.Duration            // random delay added to each scheduled daemon run (default 0)
+	ReportFile       string                   // JSON report of the last sync (default .go-cfgw/report.json)
+	ReportMarkdown   string                   // Markdown report of the last sync, empty when disabled
+	HTTPAddr         string                   // address the daemon serves health checks, status and metrics on, empty when disabled
+	HTTPToken        string                   // bearer token required by POST /sync, which is disabled without one
+	MetricsAddr      string                   // address the daemon serves Prometheus metrics on, empty when disabled
+	MetricsFile      string                   // file the metrics are written to after each sync, for the textfile collector
+	PushgatewayURL   string                   // Prometheus Pushgateway the metrics are pushed to after each sync
+	MetricsJob       string                   // Pushgateway job name (default go-cfgw)
+	TraceExporter    string                   // "none" (default without an OTLP endpoint), "otlp" or "file"
+	TraceEndpoint    string                   // OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces
+	TraceHeaders     map[string]string        // headers sent with OTLP exports, such as an API key
+	TraceFile        string                   // file spans are appended to by the file exporter (default .go-cfgw/traces.jsonl)
+	ServiceName      string                   // service.name of exported spans (default go-cfgw)
+	SourceDefaults   SourceOptions            // policy for sources without an override
+	SourceOverrides  map[string]SourceOptions // per-source policy from CONFIG_FILE, keyed by URL
 }
 
 // LoadFromEnv reads configuration from environment variables and loads a local .env file if present.
 func LoadFromEnv() (*Config, error) {
 	// Load .env if present (no-op if not found)
-	_ = godotenv.Load()
+	loadDotEnv()
 
 	// Prefer token, fall back to API key
 	token := strings.TrimSpace(os.Getenv("CLOUDFLARE_API_TOKEN"))
@@ -38,15 +89,34 @@ func LoadFromEnv() (*Config, error) {
 	acctEmail := strings.TrimSpace(os.Getenv("CLOUDFLARE_ACCOUNT_EMAIL"))
 
 	// Support legacy Node env var name CLOUDFLARE_LIST_ITEM_LIMIT as alias
-	// IMPORTANT: LIST_ITEM_LIMIT is the TOTAL limit across all lists (default 300000)
-	// LIST_ITEM_SIZE is the chunk size PER LIST (hardcoded 1000 to match Cloudflare's limit)
-	listItemSize := 1000 // Cloudflare's per-list limit, do not change
-	listItemLimit := 300000
+	// IMPORTANT: LIST_ITEM_LIMIT is the TOTAL limit across all lists
+	// LIST_ITEM_SIZE is the chunk size PER LIST
+	// All three default to 0, meaning the worker discovers them from the account
+	listItemSize := 0
+	listItemLimit := 0
+	maxLists := 0
 	if s := os.Getenv("CLOUDFLARE_LIST_ITEM_LIMIT"); s != "" {
 		if v, err := strconv.Atoi(s); err == nil && v > 0 {
 			listItemLimit = v
 		}
 	}
+	if s := os.Getenv("CLOUDFLARE_LIST_ITEM_SIZE"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			listItemSize = v
+		}
+	}
+	if s := os.Getenv("CLOUDFLARE_MAX_LISTS"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			maxLists = v
+		}
+	}
+
+	chunkConcurrency := 4
+	if s := os.Getenv("CHUNK_CONCURRENCY"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			chunkConcurrency = v
+		}
+	}
 
 	if token == "" && key == "" {
 		return nil, errors.New("one of CLOUDFLARE_API_TOKEN or CLOUDFLARE_API_KEY is required")
@@ -60,6 +130,29 @@ func LoadFromEnv() (*Config, error) {
 		apiHost = "https://api.cloudflare.com/client/v4"
 	}
 
+	// Cloudflare allows 1200 API requests per five minutes per user
+	rateLimit, err := parseRate(os.Getenv("CLOUDFLARE_RATE_LIMIT"), 1200.0/300)
+	if err != nil {
+		return nil, fmt.Errorf("CLOUDFLARE_RATE_LIMIT: %w", err)
+	}
+	rateBurst := 10
+	if s := os.Getenv("CLOUDFLARE_RATE_BURST"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			rateBurst = v
+		}
+	}
+	// Retry budgets per kind of API call
+	retries := map[string]time.Duration{"CLOUDFLARE_RETRY_READ": 2 * time.Minute, "CLOUDFLARE_RETRY_WRITE": 2 * time.Minute, "CLOUDFLARE_RETRY_DELETE": 2 * time.Minute}
+	for env := range retries {
+		if s := os.Getenv(env); s != "" {
+			v, err := time.ParseDuration(s)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid %s %q", env, s)
+			}
+			retries[env] = v
+		}
+	}
+
 	allow := readMultiEnv("ALLOWLIST_URLS")
 	// Node allowed USER_DEFINED_ALLOWLIST_URLS but also ALLOWLIST_URLS; support both
 	if len(allow) == 0 {
@@ -85,21 +178,436 @@ func LoadFromEnv() (*Config, error) {
 		bsni = true
 	}
 
-	return &Config{
+	wildcard := strings.ToLower(strings.TrimSpace(os.Getenv("WILDCARD_POLICY")))
+	switch wildcard {
+	case "":
+		wildcard = "suffix"
+	case "suffix", "reject":
+	default:
+		return nil, fmt.Errorf("WILDCARD_POLICY must be \"suffix\" or \"reject\", got %q", wildcard)
+	}
+
+	strict := false
+	if v := os.Getenv("STRICT_SOURCES"); v == "1" || strings.ToLower(v) == "true" {
+		strict = true
+	}
+
+	maxReject := 50.0
+	if s := os.Getenv("MAX_REJECT_PERCENT"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 && v <= 100 {
+			maxReject = v
+		}
+	}
+
+	// SOURCE_CACHE_DIR=off disables the on-disk source cache
+	cacheDir := strings.TrimSpace(os.Getenv("SOURCE_CACHE_DIR"))
+	switch strings.ToLower(cacheDir) {
+	case "":
+		cacheDir = filepath.Join(".go-cfgw", "cache")
+	case "off", "none", "false", "0":
+		cacheDir = ""
+	}
+
+	cacheMaxAge := 24 * time.Hour
+	if s := os.Getenv("SOURCE_CACHE_MAX_AGE"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid SOURCE_CACHE_MAX_AGE %q", s)
+		}
+		cacheMaxAge = v
+	}
+
+	workers := 4
+	if s := os.Getenv("DOWNLOAD_CONCURRENCY"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			workers = v
+		}
+	}
+	perHost := 1
+	if s := os.Getenv("DOWNLOAD_PER_HOST"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			perHost = v
+		}
+	}
+	hostInterval := 500 * time.Millisecond
+	if s := os.Getenv("DOWNLOAD_HOST_INTERVAL"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid DOWNLOAD_HOST_INTERVAL %q", s)
+		}
+		hostInterval = v
+	}
+
+	defaults := SourceOptions{Retries: 3}
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("SOURCE_POLICY"))); v {
+	case "", "required":
+	case "optional":
+		defaults.Optional = true
+	default:
+		return nil, fmt.Errorf("SOURCE_POLICY must be \"required\" or \"optional\", got %q", v)
+	}
+	if s := os.Getenv("SOURCE_RETRIES"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			defaults.Retries = v
+		}
+	}
+
+	// Sanity guards against broken or exploding sources; the minimum only applies to
+	// blocklists (see Source)
+	defaults.MinEntries = 1
+	if s := os.Getenv("GUARD_MIN_ENTRIES"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			defaults.MinEntries = v
+		}
+	}
+	if s := os.Getenv("GUARD_MAX_ENTRIES"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			defaults.MaxEntries = v
+		}
+	}
+	if s := os.Getenv("GUARD_MAX_CHANGE_PERCENT"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 {
+			defaults.MaxChangePercent = v
+		}
+	}
+	guardRebaseline := 3
+	if s := os.Getenv("GUARD_REBASELINE_AFTER"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			guardRebaseline = v
+		}
+	}
+	guardAction := strings.ToLower(strings.TrimSpace(os.Getenv("GUARD_ACTION")))
+	switch guardAction {
+	case "":
+		guardAction = "skip"
+	case "skip", "abort":
+	default:
+		return nil, fmt.Errorf("GUARD_ACTION must be \"skip\" or \"abort\", got %q", guardAction)
+	}
+	driftCheck := strings.ToLower(strings.TrimSpace(os.Getenv("DRIFT_CHECK")))
+	switch driftCheck {
+	case "":
+		driftCheck = "quick"
+	case "off", "quick", "full":
+	default:
+		return nil, fmt.Errorf("DRIFT_CHECK must be \"off\", \"quick\" or \"full\", got %q", driftCheck)
+	}
+	driftAction := strings.ToLower(strings.TrimSpace(os.Getenv("DRIFT_ACTION")))
+	switch driftAction {
+	case "":
+		driftAction = "repair"
+	case "repair", "abort":
+	default:
+		return nil, fmt.Errorf("DRIFT_ACTION must be \"repair\" or \"abort\", got %q", driftAction)
+	}
+	var lockWait time.Duration
+	if s := os.Getenv("LOCK_WAIT"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid LOCK_WAIT %q", s)
+		}
+		lockWait = v
+	}
+	remoteLock := false
+	if v := os.Getenv("REMOTE_LOCK"); v == "1" || strings.ToLower(v) == "true" {
+		remoteLock = true
+	}
+	remoteLockTTL := 30 * time.Minute
+	if s := os.Getenv("REMOTE_LOCK_TTL"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v < time.Minute {
+			return nil, fmt.Errorf("invalid REMOTE_LOCK_TTL %q (minimum 1m)", s)
+		}
+		remoteLockTTL = v
+	}
+	schedule := strings.TrimSpace(os.Getenv("SYNC_SCHEDULE"))
+	if schedule == "" {
+		schedule = "1h"
+	}
+	var jitter time.Duration
+	if s := os.Getenv("SYNC_JITTER"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid SYNC_JITTER %q", s)
+		}
+		jitter = v
+	}
+
+	metricsJob := strings.TrimSpace(os.Getenv("METRICS_JOB"))
+	if metricsJob == "" {
+		metricsJob = "go-cfgw"
+	}
+
+	// Tracing follows the OpenTelemetry SDK variables where they exist
+	traceEndpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if traceEndpoint == "" {
+		if base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+			traceEndpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	traceExporter := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER")))
+	switch traceExporter {
+	case "":
+		traceExporter = "none"
+		if traceEndpoint != "" {
+			traceExporter = "otlp"
+		}
+	case "otlp":
+		if traceEndpoint == "" {
+			traceEndpoint = "http://localhost:4318/v1/traces"
+		}
+	case "none", "file":
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER must be \"none\", \"otlp\" or \"file\", got %q", traceExporter)
+	}
+	traceHeaders, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	serviceName := strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME"))
+	if serviceName == "" {
+		serviceName = "go-cfgw"
+	}
+
+	cfg := &Config{
 		APIToken:         token,
 		APIKey:           key,
 		AccountID:        account,
 		AccountEmail:     acctEmail,
 		APIHost:          apiHost,
+		APIRateLimit:     rateLimit,
+		APIRateBurst:     rateBurst,
+		RetryRead:        retries["CLOUDFLARE_RETRY_READ"],
+		RetryWrite:       retries["CLOUDFLARE_RETRY_WRITE"],
+		RetryDelete:      retries["CLOUDFLARE_RETRY_DELETE"],
 		AllowURLs:        allow,
 		BlockURLs:        block,
 		ListItemLimit:    listItemLimit,
 		ListItemSize:     listItemSize,
+		MaxLists:         maxLists,
+		ChunkConcurrency: chunkConcurrency,
 		DryRun:           dry,
 		BlockPageEnabled: bpe,
 		BlockBasedOnSNI:  bsni,
 		DiscordWebhook:   strings.TrimSpace(os.Getenv("DISCORD_WEBHOOK_URL")),
-	}, nil
+		WildcardPolicy:   wildcard,
+		StrictSources:    strict,
+		MaxRejectPercent: maxReject,
+		CacheDir:         cacheDir,
+		CacheMaxAge:      cacheMaxAge,
+		DownloadWorkers:  workers,
+		PerHostWorkers:   perHost,
+		PerHostInterval:  hostInterval,
+		ExtraAllow:       readMultiEnv("EXTRA_ALLOW_DOMAINS"),
+		ExtraBlock:       readMultiEnv("EXTRA_BLOCK_DOMAINS"),
+		GuardAction:      guardAction,
+		GuardRebaseline:  guardRebaseline,
+		HistoryFile:      pathFromEnv("SOURCE_HISTORY_FILE", "sources.json"),
+		IndexFile:        IndexFileFromEnv(),
+		StateFile:        pathFromEnv("STATE_FILE", "state.json"),
+		DriftCheck:       driftCheck,
+		DriftAction:      driftAction,
+		LockFile:         pathFromEnv("LOCK_FILE", "sync.lock"),
+		LockWait:         lockWait,
+		RemoteLock:       remoteLock,
+		RemoteLockTTL:    remoteLockTTL,
+		Schedule:         schedule,
+		ScheduleJitter:   jitter,
+		ReportFile:       pathFromEnv("REPORT_FILE", "report.json"),
+		ReportMarkdown:   strings.TrimSpace(os.Getenv("REPORT_MARKDOWN_FILE")),
+		HTTPAddr:         strings.TrimSpace(os.Getenv("HTTP_ADDR")),
+		HTTPToken:        strings.TrimSpace(os.Getenv("HTTP_TOKEN")),
+		MetricsAddr:      strings.TrimSpace(os.Getenv("METRICS_ADDR")),
+		MetricsFile:      strings.TrimSpace(os.Getenv("METRICS_FILE")),
+		PushgatewayURL:   strings.TrimSpace(os.Getenv("PUSHGATEWAY_URL")),
+		MetricsJob:       metricsJob,
+		TraceExporter:    traceExporter,
+		TraceEndpoint:    traceEndpoint,
+		TraceHeaders:     traceHeaders,
+		TraceFile:        pathFromEnv("TRACE_FILE", "traces.jsonl"),
+		ServiceName:      serviceName,
+		SourceDefaults:   defaults,
+		SourceOverrides:  map[string]SourceOptions{},
+	}
+
+	// Optional JSON file with per-source settings
+	if path := strings.TrimSpace(os.Getenv("CONFIG_FILE")); path != "" {
+		if err := applyFile(cfg, path); err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+	}
+	if err := validateSources(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// IndexFileFromEnv returns the provenance index path from INDEX_FILE, or "" if it is
+// disabled. Unlike LoadFromEnv it needs no credentials, for offline lookups.
+func IndexFileFromEnv() string {
+	loadDotEnv()
+	return pathFromEnv("INDEX_FILE", "index.json")
+}
+
+// LogSettingsFromEnv returns LOG_LEVEL and LOG_FORMAT. Like IndexFileFromEnv it needs
+// no credentials, so the logger exists before the rest of the configuration is read.
+func LogSettingsFromEnv() (level, format string) {
+	loadDotEnv()
+	return os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT")
+}
+
+// Secrets returns the configured credentials, which must never be logged.
+func (c *Config) Secrets() []string {
+	out := []string{c.APIToken, c.APIKey, c.DiscordWebhook, c.HTTPToken, c.PushgatewayURL}
+	for _, v := range c.TraceHeaders {
+		out = append(out, v)
+	}
+	return out
+}
+
+// parseRate parses a request rate such as "1200/5m", "4/s" or "4" (per second) into
+// requests per second. "off" disables the limit.
+func parseRate(s string, def float64) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return def, nil
+	case "off", "none", "0":
+		return 0, nil
+	}
+	count, per, hasPer := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, want requests per interval like \"1200/5m\"", s)
+	}
+	window := time.Second
+	if hasPer {
+		per = strings.TrimSpace(per)
+		if per != "" && (per[0] < '0' || per[0] > '9') {
+			per = "1" + per
+		}
+		window, err = time.ParseDuration(per)
+		if err != nil || window <= 0 {
+			return 0, fmt.Errorf("invalid rate %q, want requests per interval like \"1200/5m\"", s)
+		}
+	}
+	return n / window.Seconds(), nil
+}
+
+// parseHeaders parses "key1=value1,key2=value2" with URL-encoded values, the format of
+// OTEL_EXPORTER_OTLP_HEADERS.
+func parseHeaders(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// IsLocalSource reports whether a source names local data rather than a URL to
+// download: "-" for stdin, a file:// URL, a path that is absolute or starts with ./
+// or ../, such as ./lists/block.txt, or any other path without a scheme that exists,
+// such as blocklist.txt in the working directory.
+func IsLocalSource(src string) bool {
+	if src == "-" || strings.HasPrefix(src, "file://") || filepath.IsAbs(src) {
+		return true
+	}
+	for _, prefix := range []string{"/", "./", "../", `.\`, `..\`} {
+		if strings.HasPrefix(src, prefix) {
+			return true
+		}
+	}
+	if strings.Contains(src, "://") {
+		return false
+	}
+	if _, err := os.Stat(src); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(src)
+	return len(matches) > 0
+}
+
+// validateSources rejects sources that are neither an HTTP(S) URL nor a local path,
+// so that a typo such as example.com/hosts.txt fails when the configuration loads
+// instead of as a missing file, and stdin used by more than one source.
+func validateSources(cfg *Config) error {
+	stdin := 0
+	for _, src := range append(append([]string(nil), cfg.AllowURLs...), cfg.BlockURLs...) {
+		if src == "-" {
+			if stdin++; stdin > 1 {
+				return errors.New(`the stdin source "-" can only be used once`)
+			}
+			continue
+		}
+		if IsLocalSource(src) {
+			continue
+		}
+		if !strings.Contains(src, "://") {
+			// Without a scheme and without such a file it is most likely a mistyped URL
+			return fmt.Errorf("source %q is neither a URL nor an existing file; add https:// to download it, or check the path of the local file", src)
+		}
+		if u, err := url.Parse(src); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("source %q is not an http(s) or file:// URL", src)
+		}
+	}
+	return nil
+}
+
+// dotEnvKeys remembers which variables were set from .env, so that reloading the
+// configuration picks up edits to .env without overriding the real environment.
+var (
+	dotEnvMu   sync.Mutex
+	dotEnvKeys = map[string]bool{}
+)
+
+// loadDotEnv applies .env to the environment. Variables already set by the environment
+// win, as with godotenv.Load; variables that came from .env are updated or unset to
+// match the file's current content.
+func loadDotEnv() {
+	dotEnvMu.Lock()
+	defer dotEnvMu.Unlock()
+	vals, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	for k := range dotEnvKeys {
+		if _, ok := vals[k]; !ok {
+			os.Unsetenv(k)
+			delete(dotEnvKeys, k)
+		}
+	}
+	for k, v := range vals {
+		if _, set := os.LookupEnv(k); set && !dotEnvKeys[k] {
+			continue
+		}
+		os.Setenv(k, v)
+		dotEnvKeys[k] = true
+	}
+}
+
+// pathFromEnv reads a file path setting. Unset means name inside .go-cfgw, and "off"
+// disables the file by returning "".
+func pathFromEnv(env, name string) string {
+	path := strings.TrimSpace(os.Getenv(env))
+	switch strings.ToLower(path) {
+	case "":
+		return filepath.Join(".go-cfgw", name)
+	case "off", "none", "false", "0":
+		return ""
+	}
+	return path
 }
 
 func readMultiEnv(name string) []string {
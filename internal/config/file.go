@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SourceOptions holds the download policy for a single source.
+type SourceOptions struct {
+	Optional bool     // keep syncing with last-known-good (or no) data when the source fails
+	Retries  int      // retries per URL before moving on to the next mirror
+	Mirrors  []string // fallback URLs tried in order after the primary URL
+	Format   string   // body format override: plain, gzip, bzip2, zip, tar or tar.gz
+	Member   string   // archive member name or glob to read from zip/tar sources
+
+	SHA256       string // pinned hex SHA-256 of the body
+	ChecksumURL  string // detached sha256sum-style checksum file
+	MinisignKey  string // minisign public key; enables signature verification
+	SignatureURL string // minisign signature, defaults to the source URL + ".minisig"
+
+	MinEntries       int     // fewer unique entries trips the guard (default 1 for blocklists)
+	MaxEntries       int     // more unique entries trips the guard, 0 for no limit
+	MaxChangePercent float64 // change vs the previous run that trips the guard, 0 to disable
+
+	Priority int // entries from higher priority sources are kept first when truncating
+
+	minEntriesSet bool // MinEntries was configured for this source
+}
+
+// Source returns the effective options for a source URL of kind "allow" or "block".
+// Allowlists have no entry minimum unless one is configured for the source: an empty
+// allowlist is valid, and the guard would otherwise fail it.
+func (c *Config) Source(kind, url string) SourceOptions {
+	o, ok := c.SourceOverrides[url]
+	if !ok {
+		o = c.SourceDefaults
+	}
+	if kind == "allow" && !o.minEntriesSet {
+		o.MinEntries = 0
+	}
+	return o
+}
+
+// fileConfig is the JSON document read from CONFIG_FILE.
+type fileConfig struct {
+	Sources           []fileSource `json:"sources"`
+	ExtraAllowDomains []string     `json:"extra_allow_domains"`
+	ExtraBlockDomains []string     `json:"extra_block_domains"`
+}
+
+type fileSource struct {
+	URL     string   `json:"url"`
+	List    string   `json:"list"`   // "allow" or "block"; empty only sets options for a URL from the environment
+	Policy  string   `json:"policy"` // "required" or "optional"; empty uses SOURCE_POLICY
+	Retries *int     `json:"retries"`
+	Mirrors []string `json:"mirrors"`
+	Format  string   `json:"format"`
+	Member  string   `json:"member"`
+
+	SHA256       string `json:"sha256"`
+	ChecksumURL  string `json:"checksum_url"`
+	MinisignKey  string `json:"minisign_key"`
+	SignatureURL string `json:"signature_url"`
+
+	MinEntries       *int     `json:"min_entries"`
+	MaxEntries       *int     `json:"max_entries"`
+	MaxChangePercent *float64 `json:"max_change_percent"`
+
+	Priority int `json:"priority"`
+}
+
+// applyFile merges the config file at path into cfg.
+func applyFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fc fileConfig
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for i, s := range fc.Sources {
+		url := strings.TrimSpace(s.URL)
+		if url == "" {
+			return fmt.Errorf("%s: sources[%d]: url is required", path, i)
+		}
+
+		switch strings.ToLower(s.List) {
+		case "":
+		case "allow":
+			cfg.AllowURLs = appendUnique(cfg.AllowURLs, url)
+		case "block":
+			cfg.BlockURLs = appendUnique(cfg.BlockURLs, url)
+		default:
+			return fmt.Errorf("%s: sources[%d]: list must be \"allow\" or \"block\", got %q", path, i, s.List)
+		}
+
+		opts := cfg.SourceDefaults
+		opts.Mirrors = s.Mirrors
+		opts.Member = s.Member
+		opts.Priority = s.Priority
+		opts.SHA256 = strings.TrimSpace(s.SHA256)
+		opts.ChecksumURL = strings.TrimSpace(s.ChecksumURL)
+		opts.MinisignKey = strings.TrimSpace(s.MinisignKey)
+		opts.SignatureURL = strings.TrimSpace(s.SignatureURL)
+		if opts.SHA256 != "" && len(opts.SHA256) != 64 {
+			return fmt.Errorf("%s: sources[%d]: sha256 must be 64 hex characters", path, i)
+		}
+		switch f := strings.ToLower(s.Format); f {
+		case "", "plain", "gzip", "bzip2", "zip", "tar", "tar.gz":
+			opts.Format = f
+		default:
+			return fmt.Errorf("%s: sources[%d]: unsupported format %q", path, i, s.Format)
+		}
+		switch strings.ToLower(s.Policy) {
+		case "":
+		case "required":
+			opts.Optional = false
+		case "optional":
+			opts.Optional = true
+		default:
+			return fmt.Errorf("%s: sources[%d]: policy must be \"required\" or \"optional\", got %q", path, i, s.Policy)
+		}
+		if s.MinEntries != nil {
+			opts.MinEntries = *s.MinEntries
+			opts.minEntriesSet = true
+		}
+		if s.MaxEntries != nil {
+			opts.MaxEntries = *s.MaxEntries
+		}
+		if s.MaxChangePercent != nil {
+			opts.MaxChangePercent = *s.MaxChangePercent
+		}
+		if s.Retries != nil {
+			if *s.Retries < 0 {
+				return fmt.Errorf("%s: sources[%d]: retries must not be negative", path, i)
+			}
+			opts.Retries = *s.Retries
+		}
+		cfg.SourceOverrides[url] = opts
+	}
+
+	cfg.ExtraAllow = append(cfg.ExtraAllow, fc.ExtraAllowDomains...)
+	cfg.ExtraBlock = append(cfg.ExtraBlock, fc.ExtraBlockDomains...)
+	return nil
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is the metadata stored next to each cached source body.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Format       string    `json:"format,omitempty"` // body format detected from the response headers
+	FetchedAt    time.Time `json:"fetched_at"`       // last time upstream confirmed this body (200 or 304)
+}
+
+// Age returns how long ago upstream last confirmed the cached body.
+func (e *cacheEntry) Age() time.Duration { return time.Since(e.FetchedAt) }
+
+// sourceCache keeps the last good body of each source on disk, keyed by URL.
+// A nil *sourceCache disables caching.
+type sourceCache struct {
+	dir    string
+	maxAge time.Duration
+}
+
+func newSourceCache(dir string, maxAge time.Duration) *sourceCache {
+	if dir == "" {
+		return nil
+	}
+	return &sourceCache{dir: dir, maxAge: maxAge}
+}
+
+func (c *sourceCache) paths(url string) (body, meta string) {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key+".body"), filepath.Join(c.dir, key+".json")
+}
+
+// lookup returns the cache metadata for url, or nil if there is no usable entry.
+func (c *sourceCache) lookup(url string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	bodyPath, metaPath := c.paths(url)
+	b, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || e.URL != url {
+		return nil
+	}
+	if _, err := os.Stat(bodyPath); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// open returns the cached body for url.
+func (c *sourceCache) open(url string) (*os.File, error) {
+	if c == nil {
+		return nil, errors.New("source cache disabled")
+	}
+	bodyPath, _ := c.paths(url)
+	return os.Open(bodyPath)
+}
+
+// fallback returns the cached body for url if it is younger than maxAge.
+func (c *sourceCache) fallback(url string) (*os.File, *cacheEntry, error) {
+	e := c.lookup(url)
+	if e == nil {
+		return nil, nil, errors.New("no cached copy")
+	}
+	if c.maxAge > 0 && e.Age() > c.maxAge {
+		return nil, nil, fmt.Errorf("cached copy is %v old, older than max age %v", e.Age().Round(time.Second), c.maxAge)
+	}
+	f, err := c.open(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, e, nil
+}
+
+// touch records that upstream confirmed the cached body is still current.
+func (c *sourceCache) touch(e *cacheEntry) error {
+	if c == nil {
+		return nil
+	}
+	e.FetchedAt = time.Now().UTC()
+	return c.writeMeta(e)
+}
+
+// create returns a temporary file for a new source body. The caller writes the body
+// and then calls commit or discard.
+func (c *sourceCache) create() (*os.File, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(c.dir, "body-*.tmp")
+}
+
+// commit moves a fully written temporary body into place and stores its metadata.
+func (c *sourceCache) commit(tmp *os.File, e *cacheEntry) error {
+	if c == nil || tmp == nil {
+		return nil
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	bodyPath, _ := c.paths(e.URL)
+	if err := os.Rename(tmp.Name(), bodyPath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	e.FetchedAt = time.Now().UTC()
+	return c.writeMeta(e)
+}
+
+// discard removes a temporary body after a failed download.
+func (c *sourceCache) discard(tmp *os.File) {
+	if c == nil || tmp == nil {
+		return
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+}
+
+func (c *sourceCache) writeMeta(e *cacheEntry) error {
+	_, metaPath := c.paths(e.URL)
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := metaPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metaPath)
+}
@@ -0,0 +1,260 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Source body formats. FormatPlain is a newline separated list.
+const (
+	FormatPlain = "plain"
+	FormatGzip  = "gzip"
+	FormatBzip2 = "bzip2"
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// detectFormat guesses a body's format from the URL extension, Content-Type and
+// Content-Encoding, in that order. It returns "" when nothing identifies the format.
+func detectFormat(rawURL, contentType, contentEncoding string) string {
+	format := ""
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		name = u.Path
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		format = FormatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		format = FormatTar
+	case strings.HasSuffix(name, ".gz"):
+		format = FormatGzip
+	case strings.HasSuffix(name, ".bz2"):
+		format = FormatBzip2
+	case strings.HasSuffix(name, ".zip"):
+		format = FormatZip
+	}
+
+	if format == "" && contentType != "" {
+		mt, _, _ := mime.ParseMediaType(contentType)
+		switch mt {
+		case "application/gzip", "application/x-gzip":
+			format = FormatGzip
+		case "application/x-bzip2":
+			format = FormatBzip2
+		case "application/zip", "application/x-zip-compressed":
+			format = FormatZip
+		case "application/x-tar":
+			format = FormatTar
+		case "application/x-gtar", "application/x-compressed-tar":
+			format = FormatTarGz
+		}
+	}
+
+	// Content-Encoding compresses whatever the content is
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		switch format {
+		case "", FormatPlain:
+			format = FormatGzip
+		case FormatTar:
+			format = FormatTarGz
+		}
+	case "bzip2", "x-bzip2":
+		if format == "" || format == FormatPlain {
+			format = FormatBzip2
+		}
+	}
+	return format
+}
+
+// sniffFormat identifies a body by its magic bytes.
+func sniffFormat(br *bufio.Reader) string {
+	head, _ := br.Peek(262)
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return FormatBzip2
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return FormatZip
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return FormatTar
+	}
+	return FormatPlain
+}
+
+// maxDecodedSize caps how large a source may get once decompressed and extracted.
+// Real lists stay far below it; an archive that inflates without end, broken or
+// hostile, fails its source instead of exhausting memory. Tests shorten it.
+var maxDecodedSize int64 = 256 << 20
+
+// errTooLarge is wrapped by the errors of sources that exceed maxDecodedSize.
+var errTooLarge = errors.New("source too large once decompressed")
+
+// tooLarge returns the error of source name exceeding limit bytes.
+func tooLarge(name string, limit int64) error {
+	return fmt.Errorf("%w: %s exceeds %d bytes", errTooLarge, name, limit)
+}
+
+// capReader fails with errTooLarge once more than left bytes were read.
+type capReader struct {
+	r     io.Reader
+	name  string // source, for the error
+	limit int64
+	left  int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		// Only fail if there is more to read
+		var b [1]byte
+		n, err := c.r.Read(b[:])
+		if n > 0 {
+			return 0, tooLarge(c.name, c.limit)
+		}
+		return 0, err
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// decode wraps the body of source name so it yields the plain-text list, decompressing
+// and extracting as needed. member selects an archive entry by name or glob. The
+// returned cleanup releases decompressors and temporary files and must always be
+// called. Reading more than maxDecodedSize bytes from the result fails with an error
+// wrapping errTooLarge.
+func decode(r io.Reader, name, format, member string) (io.Reader, func(), error) {
+	plain, cleanup, err := decodeFormat(r, name, format, member)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	return &capReader{r: plain, name: name, limit: maxDecodedSize, left: maxDecodedSize}, cleanup, nil
+}
+
+func decodeFormat(r io.Reader, name, format, member string) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	if format == "" {
+		format = sniffFormat(br)
+	}
+
+	switch format {
+	case FormatPlain:
+		return br, func() {}, nil
+	case FormatGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("gzip: %w", err)
+		}
+		// Some feeds publish tarballs with a plain .gz extension
+		inner := bufio.NewReader(zr)
+		if sniffFormat(inner) == FormatTar {
+			return tarMember(inner, member, func() { zr.Close() })
+		}
+		return inner, func() { zr.Close() }, nil
+	case FormatBzip2:
+		return bzip2.NewReader(br), func() {}, nil
+	case FormatTar:
+		return tarMember(br, member, func() {})
+	case FormatTarGz:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("gzip: %w", err)
+		}
+		return tarMember(zr, member, func() { zr.Close() })
+	case FormatZip:
+		return zipMember(br, name, member)
+	}
+	return nil, func() {}, fmt.Errorf("unsupported format %q", format)
+}
+
+// matchMember reports whether an archive entry name matches the member selector. An
+// empty selector matches every entry; otherwise the full name or its base name must
+// match the glob.
+func matchMember(name, member string) bool {
+	if member == "" {
+		return true
+	}
+	if ok, _ := path.Match(member, name); ok {
+		return true
+	}
+	ok, _ := path.Match(member, path.Base(name))
+	return ok
+}
+
+// tarMember streams the first regular file in a tar archive that matches member.
+func tarMember(r io.Reader, member string, cleanup func()) (io.Reader, func(), error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("tar: no member matching %q", member)
+		}
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("tar: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && matchMember(hdr.Name, member) {
+			return tr, cleanup, nil
+		}
+	}
+}
+
+// zipMember spools a zip archive to a temporary file, since the central directory is at
+// the end, and streams the first file that matches member.
+func zipMember(r io.Reader, name, member string) (io.Reader, func(), error) {
+	f, err := os.CreateTemp("", "go-cfgw-*.zip")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	remove := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	size, err := io.Copy(f, io.LimitReader(r, maxDecodedSize+1))
+	if err != nil {
+		remove()
+		return nil, func() {}, err
+	}
+	if size > maxDecodedSize {
+		remove()
+		return nil, func() {}, tooLarge(name, maxDecodedSize)
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		remove()
+		return nil, func() {}, fmt.Errorf("zip: %w", err)
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !matchMember(zf.Name, member) {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			remove()
+			return nil, func() {}, fmt.Errorf("zip: %w", err)
+		}
+		return rc, func() { rc.Close(); remove() }, nil
+	}
+	remove()
+	return nil, func() {}, fmt.Errorf("zip: no member matching %q", member)
+}
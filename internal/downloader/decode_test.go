@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// bzip2List is "bzip2.example.com\n" compressed with bzip2, which the standard library
+// can only read.
+const bzip2List = "BZh91AY&SYL\xdc\xde\n\x00\x00\x02\xd9\x80\x00\x10\x00\x01\x10\x00:&\xc0P \x001\x00\x00\x08&#\x0c\xa5\xfa4Ou\x8c\x9c\x04\x03\xf1w$S\x85\t\x04\xcd\xcd\xe0\xa0"
+
+type file struct{ name, body string }
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, files ...file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipBytes(t *testing.T, files ...file) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeAll decodes body and returns the plain text.
+func decodeAll(body []byte, format, member string) (string, error) {
+	r, cleanup, err := decode(bytes.NewReader(body), "https://lists.example.com/hosts", format, member)
+	defer cleanup()
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func TestDecode(t *testing.T) {
+	const list = "a.example.com\nb.example.com\n"
+	archive := []file{{"README.md", "# not a list\n"}, {"lists/hosts.txt", list}, {"lists/other.txt", "c.example.com\n"}}
+	tarball := tarBytes(t, archive...)
+
+	for _, tc := range []struct {
+		name   string
+		body   []byte
+		format string // "" sniffs the body
+		member string
+		want   string
+	}{
+		{"plain", []byte(list), "", "", list},
+		{"gzip", gzipBytes(t, []byte(list)), FormatGzip, "", list},
+		{"gzip sniffed", gzipBytes(t, []byte(list)), "", "", list},
+		{"bzip2", []byte(bzip2List), FormatBzip2, "", "bzip2.example.com\n"},
+		{"bzip2 sniffed", []byte(bzip2List), "", "", "bzip2.example.com\n"},
+		{"zip first member", zipBytes(t, archive...), FormatZip, "", "# not a list\n"},
+		{"zip member glob", zipBytes(t, archive...), "", "hosts.*", list},
+		{"zip member path", zipBytes(t, archive...), FormatZip, "lists/other.txt", "c.example.com\n"},
+		{"tar member glob", tarball, FormatTar, "*.txt", list},
+		{"tar sniffed", tarball, "", "hosts.txt", list},
+		{"tar.gz", gzipBytes(t, tarball), FormatTarGz, "lists/hosts.txt", list},
+		{"tar inside .gz", gzipBytes(t, tarball), FormatGzip, "hosts.txt", list},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeAll(tc.body, tc.format, tc.member)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("decoded %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeNoMember(t *testing.T) {
+	files := []file{{"hosts.txt", "a.example.com\n"}}
+	for format, body := range map[string][]byte{
+		FormatZip: zipBytes(t, files...),
+		FormatTar: tarBytes(t, files...),
+	} {
+		if _, err := decodeAll(body, format, "*.csv"); err == nil || !strings.Contains(err.Error(), "no member") {
+			t.Errorf("%s: decode = %v, want no matching member", format, err)
+		}
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	defer func(n int64) { maxDecodedSize = n }(maxDecodedSize)
+	maxDecodedSize = 1 << 10
+
+	small := strings.Repeat("x", 1<<10)
+	large := strings.Repeat("x", 1<<10+1)
+	if got, err := decodeAll(gzipBytes(t, []byte(small)), FormatGzip, ""); err != nil || len(got) != len(small) {
+		t.Fatalf("decode at the cap = %d bytes, %v; want %d bytes", len(got), err, len(small))
+	}
+	for name, body := range map[string][]byte{
+		"plain":   []byte(large),
+		"gzip":    gzipBytes(t, []byte(large)),
+		"tar.gz":  gzipBytes(t, tarBytes(t, file{"hosts.txt", large})),
+		"zip":     zipBytes(t, file{"hosts.txt", large}),
+		"zip raw": bytes.Repeat([]byte("PK\x03\x04"), 1<<9),
+	} {
+		_, err := decodeAll(body, "", "")
+		if !errors.Is(err, errTooLarge) {
+			t.Errorf("%s: decode = %v, want errTooLarge", name, err)
+		} else if !strings.Contains(err.Error(), "https://lists.example.com/hosts exceeds 1024 bytes") {
+			t.Errorf("%s: %v, want the source and limit in the error", name, err)
+		}
+	}
+}
@@ -1,28 +1,62 @@
 package downloader
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
-	"regexp"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/galpt/go-cfgw/internal/config"
 	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/tracing"
 )
 
 // Options for downloader.
 type Options struct {
 	Client *http.Client
 	Logger *logging.Logger
+	// WildcardPolicy is WildcardSuffix (default) or WildcardReject.
+	WildcardPolicy string
+	// CacheDir stores the last good copy of each source. Empty disables caching.
+	CacheDir string
+	// CacheMaxAge limits how old a cached copy may be when upstream is unavailable.
+	CacheMaxAge time.Duration
+	// Concurrency bounds how many sources are downloaded at once (default 4).
+	Concurrency int
+	// PerHostConcurrency bounds concurrent requests to a single host (default 1).
+	PerHostConcurrency int
+	// PerHostInterval is the minimum delay between request starts to a single host.
+	PerHostInterval time.Duration
+	// Stdin is read for the "-" source (default os.Stdin).
+	Stdin io.Reader
+	// GuardAction is GuardSkip (default) or GuardAbort.
+	GuardAction string
+	// HistoryFile stores per-source entry counts for the change guard. Empty disables it.
+	HistoryFile string
+	// GuardRebaseline accepts a source's new entry count as its baseline once it has
+	// tripped the change guard on this many runs in a row. 0 never does.
+	GuardRebaseline int
 }
 
 type Downloader struct {
-	client *http.Client
-	logger *logging.Logger
+	client         *http.Client
+	logger         *logging.Logger
+	wildcardPolicy string
+	cache          *sourceCache
+	concurrency    int
+	hosts          *hostLimiter
+	stdin          io.Reader
+	guardAction    string
+	history        *sourceHistory
+	rebaseline     int
 }
 
 func New(o *Options) *Downloader {
@@ -30,114 +64,527 @@ func New(o *Options) *Downloader {
 	if client == nil {
 		client = &http.Client{Timeout: 30 * time.Second}
 	}
-	return &Downloader{client: client, logger: o.Logger}
+	policy := o.WildcardPolicy
+	if policy == "" {
+		policy = WildcardSuffix
+	}
+	stdin := o.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	guardAction := o.GuardAction
+	if guardAction == "" {
+		guardAction = GuardSkip
+	}
+	concurrency := o.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	return &Downloader{
+		client:         client,
+		logger:         o.Logger,
+		wildcardPolicy: policy,
+		cache:          newSourceCache(o.CacheDir, o.CacheMaxAge),
+		concurrency:    concurrency,
+		hosts:          newHostLimiter(o.PerHostConcurrency, o.PerHostInterval),
+		stdin:          stdin,
+		guardAction:    guardAction,
+		history:        loadHistory(o.HistoryFile),
+		rebaseline:     o.GuardRebaseline,
+	}
+}
+
+// InlineSource is the source name reported for entries listed directly in the configuration.
+const InlineSource = "inline"
+
+// Result holds the normalized entries of a run together with per-source reports.
+type Result struct {
+	Allow   []string
+	Block   []string
+	Sources []*SourceReport
+	// Provenance maps each domain to the indexes in Sources of every source listing it.
+	Provenance map[string][]int
+}
+
+// Degraded returns the sources that were served from a mirror, from the cache after a
+// failure, or skipped entirely.
+func (r *Result) Degraded() []*SourceReport {
+	var out []*SourceReport
+	for _, s := range r.Sources {
+		if s.Degraded() {
+			out = append(out, s)
+		}
+	}
+	return out
 }
 
 // DownloadAndProcess downloads allow and block lists, normalizes and dedupes entries.
-func (d *Downloader) DownloadAndProcess(ctx context.Context, cfg *config.Config) (allow []string, block []string, err error) {
+// Sources are fetched concurrently, but merged in configuration order so reports and
+// results are the same regardless of which download finishes first.
+func (d *Downloader) DownloadAndProcess(ctx context.Context, cfg *config.Config) (_ *Result, err error) {
+	ctx, span := tracing.Start(ctx, "download")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	res := &Result{Provenance: map[string][]int{}}
+	for _, url := range cfg.AllowURLs {
+		res.Sources = append(res.Sources, &SourceReport{URL: url, Kind: "allow", Priority: cfg.Source("allow", url).Priority})
+	}
+	for _, url := range cfg.BlockURLs {
+		res.Sources = append(res.Sources, &SourceReport{URL: url, Kind: "block", Priority: cfg.Source("block", url).Priority})
+	}
+
+	// If no URLs were provided, return empty lists (caller may decide defaults)
+	if len(res.Sources) > 0 {
+		d.logger.Infof("Downloading %d allowlist and %d blocklist source(s) with concurrency %d...", len(cfg.AllowURLs), len(cfg.BlockURLs), d.concurrency)
+	}
+	sets, err := d.downloadAll(ctx, cfg, res.Sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inline entries from the configuration are merged after downloaded sources
+	for _, inline := range []struct {
+		kind    string
+		entries []string
+	}{{"allow", cfg.ExtraAllow}, {"block", cfg.ExtraBlock}} {
+		if len(inline.entries) == 0 {
+			continue
+		}
+		// Inline entries are explicit choices, so they outrank every source when truncating
+		rep := &SourceReport{URL: InlineSource, Kind: inline.kind, Priority: math.MaxInt32}
+		set := map[string]struct{}{}
+		if err := d.readList(strings.NewReader(strings.Join(inline.entries, "\n")), set, rep); err != nil {
+			return nil, err
+		}
+		if err := d.checkRejections(cfg, rep); err != nil {
+			return nil, err
+		}
+		res.Sources = append(res.Sources, rep)
+		sets = append(sets, set)
+	}
+
+	_, dedupe := tracing.Start(ctx, "dedupe", tracing.Int("sources", len(res.Sources)))
 	allowSet := map[string]struct{}{}
 	blockSet := map[string]struct{}{}
+	for i, rep := range res.Sources {
+		dest := blockSet
+		if rep.Kind == "allow" {
+			dest = allowSet
+		}
+		rep.Entries = len(sets[i])
+		for k := range sets[i] {
+			if _, exists := dest[k]; !exists {
+				dest[k] = struct{}{}
+				rep.Added++
+			}
+			res.Provenance[k] = append(res.Provenance[k], i)
+		}
+		d.logger.Infof("  %s: added %d unique domain(s)", rep.URL, rep.Added)
+	}
 
-	// If no URLs were provided, return empty lists (caller may decide defaults)
-	if len(cfg.AllowURLs) > 0 {
-		d.logger.Infof("Downloading %d allowlist source(s)...", len(cfg.AllowURLs))
+	res.Allow = sortedKeys(allowSet)
+	res.Block = sortedKeys(blockSet)
+	dedupe.SetAttributes(tracing.Int("allow", len(res.Allow)), tracing.Int("block", len(res.Block)))
+	dedupe.End()
+	span.SetAttributes(tracing.Int("allow", len(res.Allow)), tracing.Int("block", len(res.Block)))
+	recordSources(res.Sources)
+
+	// Remember entry counts of sources that were served fresh for the next change guard
+	for i, rep := range res.Sources {
+		if rep.URL != InlineSource && !rep.Degraded() {
+			d.history.record(rep.URL, len(sets[i]))
+		}
+	}
+	d.history.endRun()
+	if err := d.history.save(); err != nil {
+		d.logger.Warnf("save source history: %v", err)
 	}
-	for i, url := range cfg.AllowURLs {
-		d.logger.Infof("  [%d/%d] Fetching %s", i+1, len(cfg.AllowURLs), url)
-		if err := d.fetchIntoSet(ctx, url, allowSet); err != nil {
-			return nil, nil, err
+
+	if degraded := res.Degraded(); len(degraded) > 0 {
+		d.logger.Warnf("%d source(s) degraded:", len(degraded))
+		for _, s := range degraded {
+			d.logger.Warnf("  %s (%s): %s", s.URL, s.Kind, s.DegradedReason())
 		}
 	}
+	return res, nil
+}
 
-	if len(cfg.BlockURLs) > 0 {
-		d.logger.Infof("Downloading %d blocklist source(s)...", len(cfg.BlockURLs))
+// downloadAll fetches every source with a bounded worker pool. The returned sets are
+// indexed like reps. The first fatal error cancels all in-flight downloads.
+func (d *Downloader) downloadAll(ctx context.Context, cfg *config.Config, reps []*SourceReport) ([]map[string]struct{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sets := make([]map[string]struct{}, len(reps))
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
 	}
-	for i, url := range cfg.BlockURLs {
-		d.logger.Infof("  [%d/%d] Fetching %s", i+1, len(cfg.BlockURLs), url)
-		if err := d.fetchIntoSet(ctx, url, blockSet); err != nil {
-			return nil, nil, err
+
+	workers := d.concurrency
+	if workers > len(reps) {
+		workers = len(reps)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				rep := reps[i]
+				d.logger.Infof("  [%d/%d] Fetching %s", i+1, len(reps), rep.URL)
+				sctx, span := tracing.Start(ctx, "download source", tracing.String("source.url", rep.URL), tracing.String("source.kind", rep.Kind))
+				start := time.Now()
+				set, err := d.fetchSource(sctx, rep.URL, cfg.Source(rep.Kind, rep.URL), rep)
+				rep.Duration = time.Since(start)
+				if err == nil {
+					err = d.checkRejections(cfg, rep)
+				}
+				span.SetAttributes(tracing.Int("entries", len(set)), tracing.Int("attempts", rep.Attempts), tracing.Bool("skipped", rep.Skipped))
+				if rep.CacheStatus != "" {
+					span.SetAttributes(tracing.String("cache", rep.CacheStatus))
+				}
+				if rep.Mirror != "" {
+					span.SetAttributes(tracing.String("mirror", rep.Mirror))
+				}
+				span.RecordError(err)
+				span.End()
+				if err != nil {
+					fail(err)
+					continue
+				}
+				sets[i] = set
+			}
+		}()
+	}
+
+feed:
+	for i := range reps {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// sourceLogger returns the logger for messages about one source.
+func (d *Downloader) sourceLogger(rep *SourceReport) *logging.Logger {
+	return d.logger.With("source", rep.URL, "kind", rep.Kind)
+}
+
+// checkRejections logs a source's rejected lines and, in strict mode, fails when the
+// rejection rate exceeds cfg.MaxRejectPercent.
+func (d *Downloader) checkRejections(cfg *config.Config, rep *SourceReport) error {
+	log := d.sourceLogger(rep)
+	if rep.RejectedTotal() == 0 {
+		return nil
+	}
+	log.Infof("    Rejected %d of %d line(s): %s", rep.RejectedTotal(), rep.Lines, rep.RejectedSummary())
+	for _, s := range rep.Samples {
+		log.Debugf("    rejected: %q", s)
+	}
+	rate := rep.RejectionRate()
+	if rate <= cfg.MaxRejectPercent {
+		return nil
+	}
+	if cfg.StrictSources {
+		return fmt.Errorf("source %s: %.1f%% of lines rejected, above limit of %.1f%%", rep.URL, rate, cfg.MaxRejectPercent)
+	}
+	log.Warnf("source %s: %.1f%% of lines rejected, above limit of %.1f%%", rep.URL, rate, cfg.MaxRejectPercent)
+	return nil
+}
+
+// statusError is returned for non-2xx responses from a source.
+type statusError struct {
+	URL  string
+	Code int
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("http %d from %s", e.Code, e.URL) }
+
+// retryable reports whether retrying the same URL may succeed.
+func (e *statusError) retryable() bool {
+	return e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
 
-	for k := range allowSet {
-		allow = append(allow, k)
+// fetchSource downloads a single source into its own set. It tries the primary URL and
+// then each mirror with retries, falls back to the cached copy, and finally skips the
+// source if it is optional.
+func (d *Downloader) fetchSource(ctx context.Context, url string, opts config.SourceOptions, rep *SourceReport) (map[string]struct{}, error) {
+	log := d.sourceLogger(rep)
+	if isLocal(url) {
+		set, err := d.readLocal(ctx, url, opts, rep)
+		if err == nil {
+			err = d.checkGuards(url, opts, len(set))
+		}
+		var ge *guardError
+		if err != nil && opts.Optional && !(errors.As(err, &ge) && ge.Abort) {
+			log.Warnf("optional source %s unavailable, continuing without it: %v", url, err)
+			rep.resetCounts()
+			rep.Skipped = true
+			rep.Error = err.Error()
+			return map[string]struct{}{}, nil
+		}
+		return set, err
 	}
-	for k := range blockSet {
-		block = append(block, k)
+
+	var lastErr error
+	for _, u := range append([]string{url}, opts.Mirrors...) {
+		set, err := d.fetchWithRetry(ctx, url, u, opts, rep)
+		if err == nil {
+			if u != url {
+				rep.Mirror = u
+				log.Warnf("%s served from mirror %s", url, u)
+			}
+			return set, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var ge *guardError
+		if errors.As(err, &ge) && ge.Abort {
+			return nil, fmt.Errorf("%s: %w", u, err)
+		}
+		lastErr = err
+		log.Errorf("download %s: %v", u, err)
 	}
+	rep.Error = lastErr.Error()
 
-	return allow, block, nil
+	if set, err := d.fromCache(ctx, url, opts, rep, lastErr); err == nil {
+		return set, nil
+	}
+	if opts.Optional {
+		log.Warnf("optional source %s unavailable, continuing without it", url)
+		rep.resetCounts()
+		rep.Skipped = true
+		return map[string]struct{}{}, nil
+	}
+	return nil, lastErr
 }
 
-var commentPrefix = regexp.MustCompile(`^\s*(#|//|!|/\*)`)
+// fetchWithRetry fetches url with exponential backoff, retrying network errors and
+// retryable statuses up to opts.Retries times. The body is cached under key.
+func (d *Downloader) fetchWithRetry(ctx context.Context, key, url string, opts config.SourceOptions, rep *SourceReport) (map[string]struct{}, error) {
+	log := d.sourceLogger(rep)
+	var set map[string]struct{}
+	operation := func() error {
+		rep.Attempts++
+		s, err := d.fetchOnce(ctx, key, url, opts, rep)
+		if err != nil {
+			var se *statusError
+			if errors.As(err, &se) && !se.retryable() {
+				return backoff.Permanent(err)
+			}
+			var ve *verifyError
+			var ge *guardError
+			if errors.As(err, &ve) || errors.As(err, &ge) {
+				return backoff.Permanent(err)
+			}
+			log.Debugf("fetch %s attempt %d: %v", url, rep.Attempts, err)
+			return err
+		}
+		set = s
+		return nil
+	}
+
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = 2 * time.Second
+	bo := backoff.WithContext(backoff.WithMaxRetries(eb, uint64(opts.Retries)), ctx)
+	if err := backoff.Retry(operation, bo); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
 
-// hostPattern validates domain names without using lookaround (RE2 doesn't support
-// lookahead/lookbehind). Each label must be 1-63 chars, not start or end with '-'.
-// This pattern enforces those rules using explicit quantifiers.
-var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+// fetchOnce performs a single GET of url. Conditional request headers are only sent
+// when url is the source's primary URL (key), since validators are URL specific.
+func (d *Downloader) fetchOnce(ctx context.Context, key, url string, opts config.SourceOptions, rep *SourceReport) (_ map[string]struct{}, err error) {
+	ctx, span := tracing.StartKind(ctx, tracing.KindClient, "fetch", tracing.String("url.full", url), tracing.Int("attempt", rep.Attempts))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	log := d.sourceLogger(rep)
+	rep.resetCounts()
+	set := map[string]struct{}{}
 
-func (d *Downloader) fetchIntoSet(ctx context.Context, url string, dest map[string]struct{}) error {
 	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	var cached *cacheEntry
+	if url == key {
+		cached = d.cache.lookup(key)
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	release, err := d.hosts.acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
 	resp, err := d.client.Do(req)
 	if err != nil {
-		d.logger.Errorf("download %s: %v", url, err)
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	counted := &countingReader{r: resp.Body}
+	defer func() {
+		source := sourceLabel(key)
+		downloadDuration.Observe(time.Since(start).Seconds(), source)
+		downloadBytes.Add(float64(counted.n), source)
+	}()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		d.logger.Errorf("non-2xx response %d from %s", resp.StatusCode, url)
-		return fmt.Errorf("http %d from %s", resp.StatusCode, url)
-	}
-
-	count := 0
-	reader := bufio.NewReader(resp.Body)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil && err != io.EOF {
-			return err
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		f, err := d.cache.open(key)
+		if err != nil {
+			return nil, fmt.Errorf("read cached %s: %w", key, err)
+		}
+		defer f.Close()
+		rep.CacheStatus = CacheNotModified
+		log.Infof("    Not modified since last run, using cached copy")
+		dg := newDigester(opts)
+		body := dg.wrap(f)
+		if err := d.parseBody(ctx, body, formatFor(opts, cached.Format), opts.Member, set, rep); err != nil {
+			return nil, err
 		}
-		line = strings.TrimSpace(line)
-		if line == "" || commentPrefix.MatchString(line) {
-			if err == io.EOF {
-				break
+		if dg != nil {
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return nil, err
 			}
-			if err != nil {
-				return err
+			if err := d.verify(ctx, url, opts, dg); err != nil {
+				return nil, err
 			}
-			continue
 		}
-		// Basic normalization similar to original script
-		normalized := normalizeLine(line)
-		if hostPattern.MatchString(normalized) {
-			if _, exists := dest[normalized]; !exists {
-				dest[normalized] = struct{}{}
-				count++
-			}
+		if err := d.checkGuards(key, opts, len(set)); err != nil {
+			return nil, err
 		}
-		if err == io.EOF {
-			break
+		// Like a fresh body, the cached copy only counts as confirmed once it passed
+		// verification and the guards
+		if err := d.cache.touch(cached); err != nil {
+			log.Warnf("update cache metadata for %s: %v", key, err)
 		}
+		return set, nil
 	}
-	d.logger.Infof("    Added %d unique domain(s) from this source", count)
-	return nil
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &statusError{URL: url, Code: resp.StatusCode}
+	}
+
+	detected := detectFormat(url, resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"))
+	if resp.Uncompressed {
+		// net/http already removed a transport-level gzip encoding
+		detected = detectFormat(url, resp.Header.Get("Content-Type"), "")
+	}
+
+	// Parse the body while streaming a copy into the cache
+	tmp, err := d.cache.create()
+	if err != nil {
+		log.Warnf("create cache file for %s: %v", key, err)
+		tmp = nil
+	}
+	var body io.Reader = counted
+	if tmp != nil {
+		body = io.TeeReader(counted, tmp)
+	}
+	dg := newDigester(opts)
+	body = dg.wrap(body)
+	if err := d.parseBody(ctx, body, formatFor(opts, detected), opts.Member, set, rep); err != nil {
+		d.cache.discard(tmp)
+		return nil, err
+	}
+	if tmp != nil || dg != nil {
+		// Archive readers stop at the selected member; read the rest for the cache and digests
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			d.cache.discard(tmp)
+			return nil, err
+		}
+	}
+	// Only verified bodies that pass the guards reach the cache, so it stays a
+	// last-known-good copy
+	if err := d.verify(ctx, url, opts, dg); err != nil {
+		d.cache.discard(tmp)
+		return nil, err
+	}
+	if err := d.checkGuards(key, opts, len(set)); err != nil {
+		d.cache.discard(tmp)
+		return nil, err
+	}
+	entry := &cacheEntry{URL: key, Format: detected}
+	if url == key {
+		entry.ETag = resp.Header.Get("ETag")
+		entry.LastModified = resp.Header.Get("Last-Modified")
+	}
+	if err := d.cache.commit(tmp, entry); err != nil {
+		log.Warnf("write cache for %s: %v", key, err)
+	}
+	return set, nil
+}
+
+// fromCache replaces a failed download of url with its cached copy, as long as the copy
+// is younger than the configured max age. It returns cause if no usable copy exists.
+func (d *Downloader) fromCache(ctx context.Context, url string, opts config.SourceOptions, rep *SourceReport, cause error) (map[string]struct{}, error) {
+	log := d.sourceLogger(rep)
+	if d.cache == nil {
+		return nil, cause
+	}
+	f, entry, err := d.cache.fallback(url)
+	if err != nil {
+		log.Warnf("no cached copy of %s to fall back to: %v", url, err)
+		return nil, cause
+	}
+	defer f.Close()
+
+	log.Warnf("using cached copy of %s from %v ago", url, entry.Age().Round(time.Second))
+	// Discard statistics from a partially read response
+	rep.resetCounts()
+	rep.CacheStatus = CacheStale
+	rep.CacheAge = entry.Age()
+	set := map[string]struct{}{}
+	if err := d.parseBody(ctx, f, formatFor(opts, entry.Format), opts.Member, set, rep); err != nil {
+		return nil, err
+	}
+	return set, nil
 }
 
-func normalizeLine(line string) string {
-	s := line
-	// remove common hosts prefixes like 0.0.0.0 or 127.0.0.1
-	s = strings.TrimSpace(s)
-	s = strings.TrimPrefix(s, "0.0.0.0 ")
-	s = strings.TrimPrefix(s, "127.0.0.1 ")
-	s = strings.TrimPrefix(s, "::1 ")
-	s = strings.TrimPrefix(s, "||")
-	s = strings.TrimPrefix(s, "*.")
-	s = strings.TrimPrefix(s, "^")
-	// Remove any trailing metadata used by some lists
-	s = strings.Split(s, " ")[0]
-	// Remove common trailing characters used in adblock/hosts lists (e.g. caret '^', path separators)
-	s = strings.TrimRight(s, "^/\t\r\n ")
-	// Remove any surrounding pipe characters that might remain
-	s = strings.Trim(s, "|\t\r\n ")
-	return strings.ToLower(s)
+// formatFor returns the configured format of a source, or the detected one.
+func formatFor(opts config.SourceOptions, detected string) string {
+	if opts.Format != "" {
+		return opts.Format
+	}
+	return detected
 }
@@ -0,0 +1,185 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/galpt/go-cfgw/internal/config"
+)
+
+// Guard actions accepted in config.Config.GuardAction.
+const (
+	// GuardSkip treats a source that trips a guard as failed, so mirrors, the cached
+	// last-known-good copy and the optional policy apply.
+	GuardSkip = "skip"
+	// GuardAbort fails the run as soon as a source trips a guard.
+	GuardAbort = "abort"
+)
+
+// guardError is returned when a source body fails a sanity guard.
+type guardError struct {
+	Reason string
+	Abort  bool
+}
+
+func (e *guardError) Error() string { return "sanity guard: " + e.Reason }
+
+// sniffContent reports whether a body looks like an HTML page or a JSON document
+// rather than a list. It returns "" for anything else.
+func sniffContent(br *bufio.Reader) string {
+	head, _ := br.Peek(512)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	lower := bytes.ToLower(head)
+	for _, p := range []string{"<!doctype html", "<html", "<head", "<body", "<?xml"} {
+		if bytes.HasPrefix(lower, []byte(p)) {
+			return "HTML"
+		}
+	}
+	if len(head) > 1 && (head[0] == '{' || head[0] == '[') {
+		// "[Adblock Plus 2.0]" style headers also start with a bracket
+		next := bytes.TrimLeft(head[1:], " \t\r\n")
+		if len(next) == 0 || bytes.IndexByte([]byte(`{["]0123456789`), next[0]) >= 0 {
+			return "JSON"
+		}
+	}
+	return ""
+}
+
+// checkGuards validates the number of unique entries a source produced against its
+// limits and the previous run.
+func (d *Downloader) checkGuards(url string, opts config.SourceOptions, n int) error {
+	abort := d.guardAction == GuardAbort
+	if n < opts.MinEntries {
+		return &guardError{Reason: fmt.Sprintf("%d entries, below minimum of %d", n, opts.MinEntries), Abort: abort}
+	}
+	if opts.MaxEntries > 0 && n > opts.MaxEntries {
+		return &guardError{Reason: fmt.Sprintf("%d entries, above maximum of %d", n, opts.MaxEntries), Abort: abort}
+	}
+	if prev, ok := d.history.previous(url); ok && prev > 0 && opts.MaxChangePercent > 0 {
+		change := float64(n-prev) * 100 / float64(prev)
+		if change > opts.MaxChangePercent || -change > opts.MaxChangePercent {
+			reason := fmt.Sprintf("%d entries, %+.1f%% compared to %d on the previous run (limit %.1f%%)", n, change, prev, opts.MaxChangePercent)
+			// A source that keeps its new size for several runs most likely changed for
+			// good; stop holding it at the old baseline forever
+			if runs := d.history.trip(url); d.rebaseline > 0 && runs >= d.rebaseline {
+				d.logger.Warnf("%s: %s on %d runs in a row, accepting it as the new baseline", url, reason, runs)
+				return nil
+			}
+			return &guardError{Reason: reason, Abort: abort}
+		}
+	}
+	return nil
+}
+
+// sourceHistory remembers how many unique entries each source produced on its last
+// good run, for the change guard, and for how many runs in a row each source has
+// tripped it since. A nil *sourceHistory disables it.
+type sourceHistory struct {
+	path string
+
+	mu      sync.Mutex
+	counts  map[string]int
+	trips   map[string]int  // consecutive earlier runs that tripped the change guard
+	tripped map[string]bool // sources that tripped it during the current run
+}
+
+// historyFile is the JSON document of the history file.
+type historyFile struct {
+	Counts map[string]int `json:"counts"`
+	Trips  map[string]int `json:"trips,omitempty"`
+}
+
+func loadHistory(path string) *sourceHistory {
+	if path == "" {
+		return nil
+	}
+	h := &sourceHistory{path: path, counts: map[string]int{}, trips: map[string]int{}, tripped: map[string]bool{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	var f historyFile
+	if json.Unmarshal(b, &f) == nil && f.Counts != nil {
+		h.counts = f.Counts
+		if f.Trips != nil {
+			h.trips = f.Trips
+		}
+		return h
+	}
+	// Earlier versions stored only the counts
+	_ = json.Unmarshal(b, &h.counts)
+	return h
+}
+
+func (h *sourceHistory) previous(url string) (int, bool) {
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, ok := h.counts[url]
+	return n, ok
+}
+
+// trip notes that url tripped the change guard in this run and returns the number of
+// consecutive runs, including this one, that it did.
+func (h *sourceHistory) trip(url string) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tripped[url] = true
+	return h.trips[url] + 1
+}
+
+// record sets the baseline of url to n.
+func (h *sourceHistory) record(url string, n int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[url] = n
+	delete(h.trips, url)
+	delete(h.tripped, url)
+}
+
+// endRun counts the trips of the run that is ending.
+func (h *sourceHistory) endRun() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for url := range h.tripped {
+		h.trips[url]++
+	}
+	h.tripped = map[string]bool{}
+}
+
+func (h *sourceHistory) save() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	b, err := json.MarshalIndent(historyFile{Counts: h.counts, Trips: h.trips}, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+)
+
+func TestGuardMinEntries(t *testing.T) {
+	dir := t.TempDir()
+	empty, list := filepath.Join(dir, "empty.txt"), filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(empty, []byte("# nothing allowed yet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(list, []byte("a.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := New(&Options{Logger: logging.New(logging.Options{Output: io.Discard})})
+	download := func(allow, block string) error {
+		_, err := d.DownloadAndProcess(context.Background(), &config.Config{
+			AllowURLs:       []string{allow},
+			BlockURLs:       []string{block},
+			SourceDefaults:  config.SourceOptions{MinEntries: 1},
+			SourceOverrides: map[string]config.SourceOptions{},
+		})
+		return err
+	}
+
+	// The minimum only applies to blocklists; an empty allowlist is valid
+	if err := download(empty, list); err != nil {
+		t.Fatalf("empty allowlist: %v", err)
+	}
+	if err := download(list, empty); err == nil || !strings.Contains(err.Error(), "below minimum of 1") {
+		t.Fatalf("empty blocklist: %v, want the minimum entries guard to trip", err)
+	}
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hostLimiter bounds concurrent requests per host and spaces them out by a minimum
+// interval, so a single maintainer's server isn't hit by every worker at once.
+type hostLimiter struct {
+	perHost  int
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+type hostSlot struct {
+	sem  chan struct{}
+	mu   sync.Mutex
+	next time.Time // earliest start of the next request
+}
+
+func newHostLimiter(perHost int, interval time.Duration) *hostLimiter {
+	if perHost <= 0 {
+		perHost = 1
+	}
+	return &hostLimiter{perHost: perHost, interval: interval, hosts: map[string]*hostSlot{}}
+}
+
+func (l *hostLimiter) slot(host string) *hostSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.hosts[host]
+	if !ok {
+		s = &hostSlot{sem: make(chan struct{}, l.perHost)}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// acquire blocks until a request to rawURL's host may start. The returned function
+// must be called when the request is done.
+func (l *hostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return func() {}, nil
+	}
+	s := l.slot(u.Host)
+
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-s.sem }
+
+	s.mu.Lock()
+	wait := time.Until(s.next)
+	if wait < 0 {
+		wait = 0
+	}
+	s.next = time.Now().Add(wait + l.interval)
+	s.mu.Unlock()
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
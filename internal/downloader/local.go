@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/galpt/go-cfgw/internal/config"
+)
+
+// isLocal reports whether a source refers to stdin ("-"), a file:// URL or a path.
+func isLocal(src string) bool { return config.IsLocalSource(src) }
+
+// localPath converts a file:// URL to a filesystem path; other values are returned as is.
+func localPath(src string) (string, error) {
+	if !strings.HasPrefix(src, "file://") {
+		return src, nil
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	p := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		// file://relative/path is a common mistake for file:relative/path
+		p = u.Host + p
+	}
+	// file:///C:/lists/block.txt
+	if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p), nil
+}
+
+// localFiles expands a local source to the files it names: a single file, every
+// regular file in a directory, or the matches of a glob pattern, in lexical order.
+func localFiles(src string) ([]string, error) {
+	p, err := localPath(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	if strings.ContainsAny(p, "*?[") {
+		candidates, err = filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no files match %s", p)
+		}
+	} else {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return []string{p}, nil
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			candidates = append(candidates, filepath.Join(p, e.Name()))
+		}
+	}
+
+	var files []string
+	for _, c := range candidates {
+		if fi, err := os.Stat(c); err == nil && fi.Mode().IsRegular() {
+			files = append(files, c)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no regular files in %s", p)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readLocal parses a local source through the same pipeline as downloaded bodies. A
+// source with checksum or signature settings must name a single file or stdin, which
+// is verified like a download.
+func (d *Downloader) readLocal(ctx context.Context, src string, opts config.SourceOptions, rep *SourceReport) (map[string]struct{}, error) {
+	log := d.sourceLogger(rep)
+	set := map[string]struct{}{}
+	dg := newDigester(opts)
+	if src == "-" {
+		if opts.MinisignKey != "" && opts.SignatureURL == "" {
+			return nil, fmt.Errorf("read stdin: minisign verification needs signature_url")
+		}
+		if err := d.parseLocal(ctx, d.stdin, opts.Format, opts, set, rep, dg); err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+		if err := d.verify(ctx, src, opts, dg); err != nil {
+			return nil, err
+		}
+		return set, nil
+	}
+
+	files, err := localFiles(src)
+	if err != nil {
+		return nil, err
+	}
+	if dg != nil && len(files) > 1 {
+		return nil, fmt.Errorf("%s holds %d files, but checksum and signature verification need a single file", src, len(files))
+	}
+	if len(files) > 1 {
+		log.Infof("    Reading %d local file(s)", len(files))
+	}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = d.parseLocal(ctx, f, formatFor(opts, detectFormat(name, "", "")), opts, set, rep, dg)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+	}
+	if err := d.verify(ctx, src, opts, dg); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// parseLocal parses r into set, feeding all of it into dg when verification is on.
+func (d *Downloader) parseLocal(ctx context.Context, r io.Reader, format string, opts config.SourceOptions, set map[string]struct{}, rep *SourceReport, dg *digester) error {
+	body := dg.wrap(r)
+	if err := d.parseBody(ctx, body, format, opts.Member, set, rep); err != nil {
+		return err
+	}
+	if dg != nil {
+		// Archive readers stop at the selected member; the digests cover the whole file
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/metrics"
+)
+
+var (
+	sourceEntries = metrics.NewGauge("gocfgw_source_entries",
+		"Valid entries in each source during the last run.", "source", "kind")
+	sourceRejected = metrics.NewGauge("gocfgw_source_rejected_lines",
+		"Lines of each source rejected during the last run, by reason.", "source", "reason")
+	downloadDuration = metrics.NewHistogram("gocfgw_download_duration_seconds",
+		"Time to download and parse a source.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "source")
+	downloadBytes = metrics.NewCounter("gocfgw_download_bytes_total",
+		"Bytes of response body read from each source.", "source")
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// recordSources sets the per-source gauges from the reports of the last run, so
+// sources removed from the configuration disappear from the metrics.
+func recordSources(reps []*SourceReport) {
+	sourceEntries.Reset()
+	sourceRejected.Reset()
+	for _, rep := range reps {
+		source := sourceLabel(rep.URL)
+		sourceEntries.Set(float64(rep.Entries), source, rep.Kind)
+		for reason, n := range rep.Rejected {
+			sourceRejected.Set(float64(n), source, reason)
+		}
+	}
+}
+
+// sourceLabel names a source in metric labels. /metrics is usually served without
+// authentication, so the user info, query string and fragment of a URL, where access
+// tokens tend to live, are dropped and registered secrets are redacted.
+func sourceLabel(src string) string {
+	if u, err := url.Parse(src); err == nil && u.Host != "" {
+		u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+		src = u.String()
+	}
+	return logging.Redact(src)
+}
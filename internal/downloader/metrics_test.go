@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/metrics"
+)
+
+func TestMetricsHideSourceSecrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "a.example.com\nnot a domain\n")
+	}))
+	defer srv.Close()
+	src := strings.Replace(srv.URL, "://", "://user:hunter22@", 1) + "/hosts.txt?token=s3cr3t-t0ken#frag"
+
+	d := New(&Options{Logger: logging.New(logging.Options{Output: io.Discard})})
+	cfg := &config.Config{BlockURLs: []string{src}, SourceOverrides: map[string]config.SourceOptions{}}
+	if _, err := d.DownloadAndProcess(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := metrics.Default.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"s3cr3t-t0ken", "hunter22", "user:", "frag"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("metrics contain %q:\n%s", secret, out)
+		}
+	}
+	label := `source="` + srv.URL + `/hosts.txt"`
+	for _, name := range []string{"gocfgw_source_entries", "gocfgw_source_rejected_lines", "gocfgw_download_bytes_total", "gocfgw_download_duration_seconds_count"} {
+		if !strings.Contains(out, name+"{"+label) {
+			t.Errorf("no %s series for the source without its secrets:\n%s", name, out)
+		}
+	}
+}
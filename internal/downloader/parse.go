@@ -0,0 +1,245 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/tracing"
+)
+
+// Wildcard policies accepted in config.Config.WildcardPolicy.
+const (
+	// WildcardSuffix maps "*.example.com" to "example.com". Cloudflare matches
+	// list entries against dns.domains[*], which already includes every parent
+	// domain of the query, so the entry blocks example.com and all subdomains.
+	WildcardSuffix = "suffix"
+	// WildcardReject treats wildcard-prefix entries like any other unsupported pattern.
+	WildcardReject = "reject"
+)
+
+// Rejection reasons recorded in SourceReport.Rejected.
+const (
+	RejectInvalid   = "invalid"   // not a valid hostname after normalization
+	RejectWildcard  = "wildcard"  // wildcard anywhere other than a leading "*." label
+	RejectRegex     = "regex"     // /regular expression/ rules
+	RejectModifier  = "modifier"  // adblock rules with $options
+	RejectException = "exception" // adblock @@ exception rules
+)
+
+// Cache states recorded in SourceReport.CacheStatus.
+const (
+	CacheNotModified = "not-modified" // upstream answered 304, cached body reused
+	CacheStale       = "stale"        // upstream failed, cached body used as fallback
+)
+
+// maxRejectSamples bounds how many rejected lines are kept per source.
+const maxRejectSamples = 5
+
+// SourceReport summarizes what a single source contributed to a run.
+type SourceReport struct {
+	URL       string
+	Kind      string         // "allow" or "block"
+	Lines     int            // non-empty, non-comment lines seen
+	Accepted  int            // lines that produced a valid domain
+	Added     int            // accepted domains not already seen from earlier sources
+	Entries   int            // distinct valid domains of this source
+	Wildcards int            // wildcard-prefix entries mapped to suffix matches
+	Rejected  map[string]int // rejected lines by reason
+	Samples   []string       // first few rejected lines, for troubleshooting
+
+	CacheStatus string        // empty for a fresh download, otherwise CacheNotModified or CacheStale
+	CacheAge    time.Duration // age of the cached copy when CacheStatus is CacheStale
+
+	Attempts int    // download attempts across the primary URL and mirrors
+	Mirror   string // mirror URL the source was served from, if not the primary
+	Skipped  bool   // optional source that failed and had no usable cached copy
+	Error    string // last download error when the source is degraded
+	Format   string // body format when compressed or archived, empty for auto-detected
+	Priority int    // truncation priority, higher is kept first
+
+	Duration time.Duration // time spent downloading and parsing, including retries
+}
+
+// Degraded reports whether the source was not served fresh from its primary URL.
+func (r *SourceReport) Degraded() bool {
+	return r.Mirror != "" || r.CacheStatus == CacheStale || r.Skipped
+}
+
+// DegradedReason describes how a degraded source was served.
+func (r *SourceReport) DegradedReason() string {
+	switch {
+	case r.Skipped:
+		return "skipped, " + r.Error
+	case r.CacheStatus == CacheStale:
+		return fmt.Sprintf("cached copy from %v ago, %s", r.CacheAge.Round(time.Second), r.Error)
+	case r.Mirror != "":
+		return "served from mirror " + r.Mirror
+	}
+	return ""
+}
+
+// resetCounts clears statistics collected from a previous, failed attempt.
+func (r *SourceReport) resetCounts() {
+	r.Lines, r.Accepted, r.Wildcards = 0, 0, 0
+	r.Rejected, r.Samples = nil, nil
+	r.CacheStatus, r.CacheAge = "", 0
+}
+
+// Duplicates returns the accepted lines that added nothing new: repeated within the
+// source or already listed by an earlier one.
+func (r *SourceReport) Duplicates() int {
+	return r.Accepted - r.Added
+}
+
+// RejectedTotal returns the number of rejected lines across all reasons.
+func (r *SourceReport) RejectedTotal() int {
+	n := 0
+	for _, c := range r.Rejected {
+		n += c
+	}
+	return n
+}
+
+// RejectionRate returns the percentage of lines that were rejected.
+func (r *SourceReport) RejectionRate() float64 {
+	if r.Lines == 0 {
+		return 0
+	}
+	return float64(r.RejectedTotal()) * 100 / float64(r.Lines)
+}
+
+// RejectedSummary formats rejection counts as "reason=count" pairs in a stable order.
+func (r *SourceReport) RejectedSummary() string {
+	reasons := make([]string, 0, len(r.Rejected))
+	for k := range r.Rejected {
+		reasons = append(reasons, k)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, 0, len(reasons))
+	for _, k := range reasons {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, r.Rejected[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *SourceReport) reject(reason, line string) {
+	if r.Rejected == nil {
+		r.Rejected = map[string]int{}
+	}
+	r.Rejected[reason]++
+	if len(r.Samples) < maxRejectSamples {
+		r.Samples = append(r.Samples, line)
+	}
+}
+
+var commentPrefix = regexp.MustCompile(`^\s*(#|//|!|/\*)`)
+
+// hostPattern validates domain names without using lookaround (RE2 doesn't support
+// lookahead/lookbehind). Each label must be 1-63 chars, not start or end with '-'.
+// This pattern enforces those rules using explicit quantifiers.
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// parseBody decodes a possibly compressed or archived body and parses the resulting
+// list into dest. An empty format is detected from the body's magic bytes.
+func (d *Downloader) parseBody(ctx context.Context, r io.Reader, format, member string, dest map[string]struct{}, rep *SourceReport) (err error) {
+	_, span := tracing.Start(ctx, "parse", tracing.String("source.url", rep.URL), tracing.String("format", format))
+	defer func() {
+		span.SetAttributes(tracing.Int("lines", rep.Lines), tracing.Int("accepted", rep.Accepted), tracing.Int("rejected", rep.RejectedTotal()))
+		span.RecordError(err)
+		span.End()
+	}()
+	plain, cleanup, err := decode(r, rep.URL, format, member)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	rep.Format = format
+	br := bufio.NewReader(plain)
+	if kind := sniffContent(br); kind != "" {
+		return &guardError{Reason: "body looks like " + kind + " instead of a list", Abort: d.guardAction == GuardAbort}
+	}
+	return d.readList(br, dest, rep)
+}
+
+// readList parses a list body line by line into dest, recording statistics in rep.
+func (d *Downloader) readList(r io.Reader, dest map[string]struct{}, rep *SourceReport) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line != "" && !commentPrefix.MatchString(line) {
+			rep.Lines++
+			domain, wildcard, reason := parseLine(line, d.wildcardPolicy)
+			if reason != "" {
+				rep.reject(reason, line)
+			} else {
+				rep.Accepted++
+				if wildcard {
+					rep.Wildcards++
+				}
+				dest[domain] = struct{}{}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return nil
+}
+
+// parseLine normalizes a single list line. It returns the domain to add, whether the
+// line was a wildcard-prefix entry, or a non-empty rejection reason.
+func parseLine(line, wildcardPolicy string) (domain string, wildcard bool, reason string) {
+	if strings.HasPrefix(line, "@@") {
+		return "", false, RejectException
+	}
+	if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+		return "", false, RejectRegex
+	}
+
+	s := normalizeLine(line)
+	if strings.Contains(s, "$") {
+		return "", false, RejectModifier
+	}
+	if strings.HasPrefix(s, "*.") {
+		if wildcardPolicy == WildcardReject {
+			return "", false, RejectWildcard
+		}
+		s = strings.TrimPrefix(s, "*.")
+		wildcard = true
+	}
+	if strings.Contains(s, "*") {
+		return "", false, RejectWildcard
+	}
+	if !hostPattern.MatchString(s) {
+		return "", false, RejectInvalid
+	}
+	return s, wildcard, ""
+}
+
+func normalizeLine(line string) string {
+	s := line
+	// remove common hosts prefixes like 0.0.0.0 or 127.0.0.1
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "0.0.0.0 ")
+	s = strings.TrimPrefix(s, "127.0.0.1 ")
+	s = strings.TrimPrefix(s, "::1 ")
+	s = strings.TrimPrefix(s, "||")
+	s = strings.TrimPrefix(s, "^")
+	// Remove any trailing metadata used by some lists
+	s = strings.Split(s, " ")[0]
+	// Remove common trailing characters used in adblock/hosts lists (e.g. caret '^', path separators)
+	s = strings.TrimRight(s, "^/\t\r\n ")
+	// Remove any surrounding pipe characters that might remain
+	s = strings.Trim(s, "|\t\r\n ")
+	return strings.ToLower(s)
+}
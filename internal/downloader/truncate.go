@@ -0,0 +1,97 @@
+package downloader
+
+import "sort"
+
+// Truncation describes entries dropped to fit a list item limit.
+type Truncation struct {
+	Limit           int
+	Lists           int            // lists available to the run
+	ListSize        int            // items per list, 0 if only Limit applied
+	Before          int            // allow + block entries before truncation
+	Dropped         []string       // dropped domains, in drop order (lowest score first)
+	DroppedBySource map[string]int // dropped domains per contributing source URL
+}
+
+// score ranks an entry for truncation: entries from higher priority sources win, then
+// entries listed by more sources, then lexical order so the result is deterministic.
+type score struct {
+	domain   string
+	priority int
+	sources  int
+}
+
+func less(a, b score) bool {
+	if a.priority != b.priority {
+		return a.priority < b.priority
+	}
+	if a.sources != b.sources {
+		return a.sources < b.sources
+	}
+	return a.domain > b.domain
+}
+
+// Truncate drops the lowest scoring entries so that len(Allow)+len(Block) <= limit, if
+// limit > 0, and, if listSize > 0, so that allow and block entries, chunked separately in lists of
+// listSize items, take up no more than lists lists. The allowlist is budgeted first in
+// whole lists and the blocklist gets the lists left over, so blocklist entries are
+// dropped first; allowlist entries only if they alone exceed the budget. It returns nil
+// when nothing had to be dropped.
+func (r *Result) Truncate(limit, lists, listSize int) *Truncation {
+	keepAllow, keepBlock := len(r.Allow), len(r.Block)
+	if limit > 0 {
+		keepAllow = min(keepAllow, limit)
+		keepBlock = min(keepBlock, limit-keepAllow)
+	}
+	if listSize > 0 {
+		keepAllow = min(keepAllow, lists*listSize)
+		allowLists := (keepAllow + listSize - 1) / listSize
+		keepBlock = min(keepBlock, (lists-allowLists)*listSize)
+	}
+	if keepAllow == len(r.Allow) && keepBlock == len(r.Block) {
+		return nil
+	}
+	t := &Truncation{Limit: limit, Lists: lists, ListSize: listSize, Before: len(r.Allow) + len(r.Block), DroppedBySource: map[string]int{}}
+	r.Allow = r.truncateKind(r.Allow, "allow", keepAllow, t)
+	r.Block = r.truncateKind(r.Block, "block", keepBlock, t)
+	return t
+}
+
+// truncateKind keeps the keep best scoring entries of one kind, in sorted order.
+func (r *Result) truncateKind(entries []string, kind string, keep int, t *Truncation) []string {
+	if len(entries) <= keep {
+		return entries
+	}
+	scores := make([]score, len(entries))
+	for i, domain := range entries {
+		s := score{domain: domain}
+		for _, src := range r.Provenance[domain] {
+			rep := r.Sources[src]
+			if rep.Kind != kind {
+				continue
+			}
+			s.sources++
+			if s.sources == 1 || rep.Priority > s.priority {
+				s.priority = rep.Priority
+			}
+		}
+		scores[i] = s
+	}
+	sort.Slice(scores, func(i, j int) bool { return less(scores[i], scores[j]) })
+
+	drop := len(entries) - keep
+	for _, s := range scores[:drop] {
+		t.Dropped = append(t.Dropped, s.domain)
+		for _, src := range r.Provenance[s.domain] {
+			if rep := r.Sources[src]; rep.Kind == kind {
+				t.DroppedBySource[rep.URL]++
+			}
+		}
+	}
+
+	kept := make([]string, 0, keep)
+	for _, s := range scores[drop:] {
+		kept = append(kept, s.domain)
+	}
+	sort.Strings(kept)
+	return kept
+}
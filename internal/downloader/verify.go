@@ -0,0 +1,242 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"golang.org/x/crypto/blake2b"
+)
+
+// maxSidecarSize bounds checksum and signature downloads.
+const maxSidecarSize = 64 << 10
+
+// verifyError is returned when a body fails checksum or signature verification.
+type verifyError struct {
+	URL string
+	Err error
+}
+
+func (e *verifyError) Error() string { return fmt.Sprintf("verify %s: %v", e.URL, e.Err) }
+func (e *verifyError) Unwrap() error { return e.Err }
+
+// needsVerify reports whether any integrity check is configured for a source.
+func needsVerify(opts config.SourceOptions) bool {
+	return opts.SHA256 != "" || opts.ChecksumURL != "" || opts.MinisignKey != ""
+}
+
+// digester hashes a body while it is being parsed.
+type digester struct {
+	sha256  hash.Hash
+	blake2b hash.Hash
+}
+
+func newDigester(opts config.SourceOptions) *digester {
+	if !needsVerify(opts) {
+		return nil
+	}
+	b2, _ := blake2b.New512(nil)
+	return &digester{sha256: sha256.New(), blake2b: b2}
+}
+
+// wrap returns a reader that feeds everything read from r into the digests.
+func (dg *digester) wrap(r io.Reader) io.Reader {
+	if dg == nil {
+		return r
+	}
+	return io.TeeReader(r, io.MultiWriter(dg.sha256, dg.blake2b))
+}
+
+// verify checks the digests of the body fetched from rawURL against the source's
+// pinned SHA-256, its detached checksum file and its minisign signature.
+func (d *Downloader) verify(ctx context.Context, rawURL string, opts config.SourceOptions, dg *digester) error {
+	if dg == nil {
+		return nil
+	}
+	sum := hex.EncodeToString(dg.sha256.Sum(nil))
+
+	if opts.SHA256 != "" && !strings.EqualFold(opts.SHA256, sum) {
+		return &verifyError{URL: rawURL, Err: fmt.Errorf("sha256 %s does not match pinned %s", sum, opts.SHA256)}
+	}
+
+	if opts.ChecksumURL != "" {
+		b, err := d.fetchSidecar(ctx, opts.ChecksumURL)
+		if err != nil {
+			return &verifyError{URL: rawURL, Err: fmt.Errorf("checksum file: %w", err)}
+		}
+		want, err := parseChecksumFile(b, fileName(rawURL))
+		if err != nil {
+			return &verifyError{URL: rawURL, Err: fmt.Errorf("checksum file %s: %w", opts.ChecksumURL, err)}
+		}
+		if !strings.EqualFold(want, sum) {
+			return &verifyError{URL: rawURL, Err: fmt.Errorf("sha256 %s does not match %s from %s", sum, want, opts.ChecksumURL)}
+		}
+	}
+
+	if opts.MinisignKey != "" {
+		sigURL := opts.SignatureURL
+		if sigURL == "" {
+			sigURL = withSuffix(rawURL, ".minisig")
+		}
+		b, err := d.fetchSidecar(ctx, sigURL)
+		if err != nil {
+			return &verifyError{URL: rawURL, Err: fmt.Errorf("signature: %w", err)}
+		}
+		if err := verifyMinisign(opts.MinisignKey, b, dg.blake2b.Sum(nil)); err != nil {
+			return &verifyError{URL: rawURL, Err: fmt.Errorf("signature %s: %w", sigURL, err)}
+		}
+	}
+	return nil
+}
+
+// fileName returns the name of the file a source URL or path points to, leaving out
+// any query string or fragment.
+func fileName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && !isLocal(rawURL) {
+		return path.Base(u.Path)
+	}
+	return filepath.Base(rawURL)
+}
+
+// withSuffix appends suffix to the path of a source URL, keeping its query string, so
+// that list.txt?dl=1 becomes list.txt.minisig?dl=1. Local paths get it appended as is.
+func withSuffix(rawURL, suffix string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || isLocal(rawURL) {
+		return rawURL + suffix
+	}
+	u.Path += suffix
+	u.RawPath = ""
+	return u.String()
+}
+
+// fetchSidecar downloads a small checksum or signature file, or reads it when it is a
+// local path. It bypasses the per-host limiter, since it runs while the source body
+// still holds the host's slot.
+func (d *Downloader) fetchSidecar(ctx context.Context, rawURL string) ([]byte, error) {
+	if isLocal(rawURL) && rawURL != "-" {
+		p, err := localPath(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxSidecarSize))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &statusError{URL: rawURL, Code: resp.StatusCode}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSidecarSize))
+}
+
+// parseChecksumFile extracts a SHA-256 digest from sha256sum-style output. Lines may
+// be a bare digest or "digest  filename"; the line naming the source's file wins. A
+// file without such a line must hold exactly one digest, since picking one of several
+// could vouch for a different file.
+func parseChecksumFile(b []byte, name string) (string, error) {
+	var digests []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil {
+			continue
+		}
+		if len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+		digests = append(digests, fields[0])
+	}
+	switch len(digests) {
+	case 0:
+		return "", errors.New("no sha256 digest found")
+	case 1:
+		return digests[0], nil
+	}
+	return "", fmt.Errorf("%d digests listed but none for %s", len(digests), name)
+}
+
+// verifyMinisign checks a minisign signature file against a public key (the base64
+// key line, optionally preceded by its "untrusted comment:" line) and the BLAKE2b-512
+// digest of the signed file. Only prehashed ("ED") signatures are supported.
+func verifyMinisign(pubKey string, sigFile []byte, digest []byte) error {
+	keyLine := ""
+	for _, l := range strings.Split(strings.ReplaceAll(pubKey, "\r", ""), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			keyLine = l
+			break
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(keyLine)
+	if err != nil || len(key) != 2+8+ed25519.PublicKeySize || string(key[:2]) != "Ed" {
+		return errors.New("invalid minisign public key")
+	}
+	keyID, pk := key[2:10], ed25519.PublicKey(key[10:])
+
+	var lines []string
+	for _, l := range strings.Split(strings.ReplaceAll(string(sigFile), "\r", ""), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	switch string(sig[:2]) {
+	case "ED":
+	case "Ed":
+		return errors.New("legacy (non-prehashed) signatures are not supported, re-sign with a current minisign")
+	default:
+		return fmt.Errorf("unknown signature algorithm %q", sig[:2])
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return errors.New("signature was made with a different key")
+	}
+	if !ed25519.Verify(pk, digest, sig[10:]) {
+		return errors.New("signature does not match")
+	}
+
+	// The global signature covers the signature and the trusted comment
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("malformed global signature")
+	}
+	if !ed25519.Verify(pk, append(append([]byte{}, sig[10:]...), trusted...), global) {
+		return errors.New("trusted comment signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/galpt/go-cfgw/internal/config"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	goodBody     = "a.example.com\nb.example.com\n"
+	tamperedBody = "a.example.com\nb.example.com\nevil.example.com\n"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// minisignKey is a minisign key pair for tests.
+type minisignKey struct {
+	id [8]byte
+	sk ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T, id byte) *minisignKey {
+	t.Helper()
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &minisignKey{id: [8]byte{id, 1, 2, 3, 4, 5, 6, 7}, sk: sk}
+}
+
+// public returns the key in the format of a minisign .pub file.
+func (k *minisignKey) public() string {
+	b := append(append([]byte("Ed"), k.id[:]...), k.sk.Public().(ed25519.PublicKey)...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+}
+
+// sign returns a signature file for body. Algorithm "ED" signs the BLAKE2b-512 digest
+// like current minisign does, "Ed" signs the body itself.
+func (k *minisignKey) sign(body, alg, trusted string) string {
+	msg := []byte(body)
+	if alg == "ED" {
+		sum := blake2b.Sum512(msg)
+		msg = sum[:]
+	}
+	sig := ed25519.Sign(k.sk, msg)
+	global := ed25519.Sign(k.sk, append(append([]byte{}, sig...), trusted...))
+	b := append(append([]byte(alg), k.id[:]...), sig...)
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(b) + "\n" +
+		"trusted comment: " + trusted + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+// verifyBody hashes body like fetchOnce does and verifies it as if fetched from rawURL.
+func verifyBody(t *testing.T, rawURL, body string, opts config.SourceOptions) error {
+	t.Helper()
+	d := New(&Options{})
+	dg := newDigester(opts)
+	if _, err := io.Copy(io.Discard, dg.wrap(strings.NewReader(body))); err != nil {
+		t.Fatal(err)
+	}
+	return d.verify(context.Background(), rawURL, opts, dg)
+}
+
+// sidecars serves files by path.
+func sidecars(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifySHA256(t *testing.T) {
+	opts := config.SourceOptions{SHA256: strings.ToUpper(sha256Hex(goodBody))}
+	if err := verifyBody(t, "https://lists.example.com/hosts.txt", goodBody, opts); err != nil {
+		t.Fatalf("good body: %v", err)
+	}
+	var ve *verifyError
+	if err := verifyBody(t, "https://lists.example.com/hosts.txt", tamperedBody, opts); !errors.As(err, &ve) {
+		t.Fatalf("tampered body: %v, want a verify error", err)
+	}
+}
+
+func TestVerifyChecksumFile(t *testing.T) {
+	good, tampered := sha256Hex(goodBody), sha256Hex(tamperedBody)
+	srv := sidecars(t, map[string]string{
+		"/named.sha256":  tampered + "  other.txt\n" + good + " *hosts.txt\n",
+		"/single.sha256": good + "  hosts-latest.txt\n",
+		"/bare.sha256":   "# checksum\n" + good + "\n",
+		"/many.sha256":   good + "  hosts-latest.txt\n" + tampered + "  other.txt\n",
+		"/empty.sha256":  "not a digest\n",
+	})
+	rawURL := srv.URL + "/hosts.txt"
+
+	for _, tc := range []struct {
+		file string
+		body string
+		ok   bool
+	}{
+		{"named", goodBody, true},
+		{"named", tamperedBody, false},
+		{"single", goodBody, true},
+		{"single", tamperedBody, false},
+		{"bare", goodBody, true},
+		{"bare", tamperedBody, false},
+		// Several digests and none for hosts.txt, even though one matches the body
+		{"many", goodBody, false},
+		{"empty", goodBody, false},
+		{"missing", goodBody, false},
+	} {
+		opts := config.SourceOptions{ChecksumURL: srv.URL + "/" + tc.file + ".sha256"}
+		err := verifyBody(t, rawURL, tc.body, opts)
+		var ve *verifyError
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%s, %q: %v", tc.file, tc.body, err)
+		case !tc.ok && !errors.As(err, &ve):
+			t.Errorf("%s, %q: %v, want a verify error", tc.file, tc.body, err)
+		}
+	}
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	key, other := newMinisignKey(t, 1), newMinisignKey(t, 2)
+	good := key.sign(goodBody, "ED", "timestamp:1700000000\tfile:hosts.txt")
+	lines := strings.Split(good, "\n")
+	lines[2] = "trusted comment: timestamp:1800000000\tfile:hosts.txt"
+	forgedComment := strings.Join(lines, "\n")
+
+	srv := sidecars(t, map[string]string{
+		"/hosts.txt.minisig": good,
+		"/legacy.minisig":    key.sign(goodBody, "Ed", "legacy"),
+		"/other.minisig":     other.sign(goodBody, "ED", "other key"),
+		"/comment.minisig":   forgedComment,
+		"/garbage.minisig":   "untrusted comment: nothing\n",
+	})
+	rawURL := srv.URL + "/hosts.txt"
+
+	for _, tc := range []struct {
+		name    string
+		sig     string // signature path, "" for the default next to the source
+		body    string
+		wantErr string
+	}{
+		{"good", "", goodBody, ""},
+		{"tampered body", "", tamperedBody, "signature does not match"},
+		{"legacy signature", "/legacy.minisig", goodBody, "not supported"},
+		{"different key", "/other.minisig", goodBody, "different key"},
+		{"forged trusted comment", "/comment.minisig", goodBody, "trusted comment"},
+		{"malformed", "/garbage.minisig", goodBody, "malformed"},
+		{"missing", "/missing.minisig", goodBody, "404"},
+	} {
+		opts := config.SourceOptions{MinisignKey: key.public()}
+		if tc.sig != "" {
+			opts.SignatureURL = srv.URL + tc.sig
+		}
+		err := verifyBody(t, rawURL, tc.body, opts)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var ve *verifyError
+		if !errors.As(err, &ve) || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: %v, want a verify error containing %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestVerifyMinisignKey(t *testing.T) {
+	key := newMinisignKey(t, 1)
+	sum := blake2b.Sum512([]byte(goodBody))
+	sig := []byte(key.sign(goodBody, "ED", "trusted"))
+	// The bare key line works as well as the whole .pub file
+	bare := strings.Split(key.public(), "\n")[1]
+	if err := verifyMinisign(bare, sig, sum[:]); err != nil {
+		t.Fatalf("bare key line: %v", err)
+	}
+	if err := verifyMinisign("RWQ=", sig, sum[:]); err == nil || !strings.Contains(err.Error(), "public key") {
+		t.Fatalf("short key: %v, want invalid public key", err)
+	}
+}
+
+func TestVerifyURLWithQuery(t *testing.T) {
+	key := newMinisignKey(t, 1)
+	srv := sidecars(t, map[string]string{
+		"/hosts.txt.sha256":  sha256Hex(tamperedBody) + "  other.txt\n" + sha256Hex(goodBody) + "  hosts.txt\n",
+		"/hosts.txt.minisig": key.sign(goodBody, "ED", "file:hosts.txt"),
+	})
+	rawURL := srv.URL + "/hosts.txt?dl=1#top"
+
+	opts := config.SourceOptions{ChecksumURL: srv.URL + "/hosts.txt.sha256", MinisignKey: key.public()}
+	if err := verifyBody(t, rawURL, goodBody, opts); err != nil {
+		t.Fatalf("good body: %v", err)
+	}
+	var ve *verifyError
+	if err := verifyBody(t, rawURL, tamperedBody, opts); !errors.As(err, &ve) {
+		t.Fatalf("tampered body: %v, want a verify error", err)
+	}
+	if got, want := withSuffix(rawURL, ".minisig"), srv.URL+"/hosts.txt.minisig?dl=1#top"; got != want {
+		t.Errorf("signature URL %s, want %s", got, want)
+	}
+}
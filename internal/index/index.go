@@ -0,0 +1,149 @@
+// Package index persists where every domain of a run came from and which Cloudflare
+// list it was written to, so a blocked domain can be traced back to its sources.
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/downloader"
+)
+
+// Source is a list source of a run.
+type Source struct {
+	URL  string `json:"url"`
+	Kind string `json:"kind"` // "allow" or "block"
+}
+
+// Chunk is a Cloudflare list created by a run. Entries are written in sorted order, so
+// a chunk holds every entry of its kind between First and Last.
+type Chunk struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"` // empty in dry-run
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Count int    `json:"count"`
+}
+
+// Index is the provenance index of one run.
+type Index struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+	Sources     []Source  `json:"sources"`
+	// Domains maps each domain to the indexes in Sources of every source listing it.
+	Domains map[string][]int `json:"domains"`
+	// Dropped lists domains removed to fit the account's list limits.
+	Dropped []string `json:"dropped,omitempty"`
+	Chunks  []Chunk  `json:"chunks"`
+}
+
+// Build creates the index of a run from its download result, the truncation applied
+// to it (may be nil) and the chunks written to Cloudflare.
+func Build(res *downloader.Result, t *downloader.Truncation, chunks []Chunk, dryRun bool) *Index {
+	ix := &Index{
+		GeneratedAt: time.Now().UTC(),
+		DryRun:      dryRun,
+		Domains:     res.Provenance,
+		Chunks:      chunks,
+	}
+	for _, rep := range res.Sources {
+		ix.Sources = append(ix.Sources, Source{URL: rep.URL, Kind: rep.Kind})
+	}
+	if t != nil {
+		ix.Dropped = append([]string(nil), t.Dropped...)
+		sort.Strings(ix.Dropped)
+	}
+	return ix
+}
+
+// Save writes the index to path atomically.
+func (ix *Index) Save(path string) error {
+	b, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads an index written by Save.
+func Load(path string) (*Index, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ix Index
+	if err := json.Unmarshal(b, &ix); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	sort.Strings(ix.Dropped)
+	return &ix, nil
+}
+
+// Match is a listing of a looked up domain, directly or through a parent domain.
+type Match struct {
+	Domain  string   // listed domain: the looked up domain or one of its parents
+	Kind    string   // "allow" or "block"
+	Sources []string // URLs of the sources listing Domain
+	Dropped bool     // removed to fit the account's list limits
+	Chunk   *Chunk   // list Domain was written to, nil if dropped
+}
+
+// Lookup returns every listing that applies to domain. Gateway matches a list entry
+// against the queried name and all of its parents, so parents are checked too, most
+// specific first.
+func (ix *Index) Lookup(domain string) []Match {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	var out []Match
+	for cand := domain; cand != ""; {
+		byKind := map[string][]string{}
+		for _, i := range ix.Domains[cand] {
+			if i >= 0 && i < len(ix.Sources) {
+				src := ix.Sources[i]
+				byKind[src.Kind] = append(byKind[src.Kind], src.URL)
+			}
+		}
+		for _, kind := range []string{"block", "allow"} {
+			urls, ok := byKind[kind]
+			if !ok {
+				continue
+			}
+			m := Match{Domain: cand, Kind: kind, Sources: urls, Chunk: ix.chunkFor(kind, cand)}
+			m.Dropped = m.Chunk == nil && ix.dropped(cand)
+			out = append(out, m)
+		}
+		dot := strings.IndexByte(cand, '.')
+		if dot < 0 {
+			break
+		}
+		cand = cand[dot+1:]
+	}
+	return out
+}
+
+func (ix *Index) chunkFor(kind, domain string) *Chunk {
+	for i := range ix.Chunks {
+		c := &ix.Chunks[i]
+		if c.Kind == kind && c.First <= domain && domain <= c.Last {
+			return c
+		}
+	}
+	return nil
+}
+
+func (ix *Index) dropped(domain string) bool {
+	i := sort.SearchStrings(ix.Dropped, domain)
+	return i < len(ix.Dropped) && ix.Dropped[i] == domain
+}
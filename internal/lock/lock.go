@@ -0,0 +1,55 @@
+// Package lock provides an exclusive lock file so that only one sync runs at a time
+// on a machine.
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when the lock is held by another process.
+var ErrLocked = errors.New("lock is held by another process")
+
+// pollInterval is how often a waiting Acquire retries.
+const pollInterval = time.Second
+
+// File is a held lock file.
+type File struct {
+	path string
+	f    *os.File
+}
+
+// Acquire takes the lock file at path, retrying until wait has passed. holder describes
+// this process and is written into the file for others to report. It returns ErrLocked
+// if the lock is still held after wait.
+func Acquire(ctx context.Context, path, holder string, wait time.Duration) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		l, err := tryAcquire(path, holder)
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Holder returns the holder description written by the process holding the lock at
+// path, or "" if unknown.
+func Holder(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
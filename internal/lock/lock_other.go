@@ -0,0 +1,39 @@
+//go:build !unix
+
+package lock
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// staleAfter is how old a lock file may get before it is considered left behind by a
+// crashed run. Without flock there is no way to tell whether its owner is alive.
+const staleAfter = 6 * time.Hour
+
+// tryAcquire creates path exclusively. An existing file older than staleAfter is
+// removed and the lock taken over.
+func tryAcquire(path, holder string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		if fi, serr := os.Stat(path); serr == nil && time.Since(fi.ModTime()) > staleAfter {
+			if rerr := os.Remove(path); rerr == nil {
+				return tryAcquire(path, holder)
+			}
+		}
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _ = f.WriteString(holder + "\n")
+	return &File{path: path, f: f}, nil
+}
+
+// Release gives up the lock.
+func (l *File) Release() error {
+	l.f.Close()
+	return os.Remove(l.path)
+}
@@ -1,39 +1,168 @@
+// Package logging provides go-cfgw's leveled, structured logger. It is built on
+// log/slog, writes either the classic "cfgw: <time> LEVEL: message key=value" text
+// lines or JSON, and redacts registered secrets from every record.
 package logging
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 )
 
-// Logger is a tiny wrapper around the standard logger for tests and verbosity control.
+// Output formats accepted in Options.Format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures a Logger.
+type Options struct {
+	Level  slog.Level // minimum level written (default info)
+	Format string     // FormatText (default) or FormatJSON
+	Output io.Writer  // default os.Stdout
+}
+
+// Logger is a printf-style front end to a slog.Logger. Fields added with With are
+// attached to every record it writes.
 type Logger struct {
-	debug bool
-	std   *log.Logger
+	s    *slog.Logger
+	caps *captures // shared by all loggers derived with With
+}
+
+// New returns a logger for o.
+func New(o Options) *Logger {
+	out := o.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	var h slog.Handler
+	if o.Format == FormatJSON {
+		h = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: o.Level})
+	} else {
+		h = newTextHandler(out, o.Level)
+	}
+	caps := &captures{}
+	return &Logger{s: slog.New(&redactHandler{next: h, caps: caps}), caps: caps}
 }
 
-// NewLogger returns a logger. If debug is true, debug logs are enabled.
+// NewLogger returns a text logger at info level, or debug level if debug is true.
 func NewLogger(debug bool) *Logger {
-	return &Logger{debug: debug, std: log.New(os.Stdout, "cfgw: ", log.LstdFlags)}
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	return New(Options{Level: level})
+}
+
+// ParseLevel parses "debug", "info", "warn" (or "warning") and "error".
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
 }
 
-func (l *Logger) Debugf(format string, v ...interface{}) {
-	if l.debug {
-		l.std.Printf("DEBUG: "+format, v...)
+// ParseFormat validates a format name; empty means FormatText.
+func ParseFormat(s string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(s)); f {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return f, nil
 	}
+	return "", fmt.Errorf("unknown log format %q (want text or json)", s)
 }
 
-func (l *Logger) Infof(format string, v ...interface{}) {
-	l.std.Printf("INFO: "+format, v...)
+// With returns a logger that adds the given key-value pairs to every record, such as
+// With("source", url) or With("list", name, "chunk", 3).
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{s: l.s.With(args...), caps: l.caps}
+}
+
+// Capture starts collecting the messages that l, and every logger derived from it,
+// writes at level or above, such as the warnings of one sync for its report. The
+// returned function ends the capture and returns the messages in order.
+func (l *Logger) Capture(level slog.Level) (stop func() []string) {
+	c := &capture{level: level}
+	l.caps.mu.Lock()
+	l.caps.active = append(l.caps.active, c)
+	l.caps.mu.Unlock()
+	return func() []string {
+		l.caps.mu.Lock()
+		defer l.caps.mu.Unlock()
+		for i, a := range l.caps.active {
+			if a == c {
+				l.caps.active = append(l.caps.active[:i], l.caps.active[i+1:]...)
+				break
+			}
+		}
+		return c.msgs
+	}
 }
 
-func (l *Logger) Warnf(format string, v ...interface{}) {
-	l.std.Printf("WARN: "+format, v...)
+type capture struct {
+	level slog.Level
+	msgs  []string
 }
 
-func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.std.Printf("ERROR: "+format, v...)
+type captures struct {
+	mu     sync.Mutex
+	active []*capture
 }
 
-func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.std.Fatalf("FATAL: "+format, v...)
+func (cs *captures) wants(level slog.Level) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, c := range cs.active {
+		if level >= c.level {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *captures) record(level slog.Level, msg string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, c := range cs.active {
+		if level >= c.level {
+			c.msgs = append(c.msgs, msg)
+		}
+	}
+}
+
+// Slog returns the underlying slog.Logger.
+func (l *Logger) Slog() *slog.Logger { return l.s }
+
+// Enabled reports whether records at level are written, to skip building expensive
+// debug output.
+func (l *Logger) Enabled(level slog.Level) bool {
+	return l.s.Enabled(context.Background(), level)
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(slog.LevelDebug, format, v...) }
+
+func (l *Logger) Infof(format string, v ...interface{}) { l.logf(slog.LevelInfo, format, v...) }
+
+func (l *Logger) Warnf(format string, v ...interface{}) { l.logf(slog.LevelWarn, format, v...) }
+
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(slog.LevelError, format, v...) }
+
+func (l *Logger) logf(level slog.Level, format string, v ...interface{}) {
+	ctx := context.Background()
+	if !l.s.Enabled(ctx, level) {
+		return
+	}
+	l.s.Log(ctx, level, fmt.Sprintf(format, v...))
 }
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in log output.
+const Redacted = "[REDACTED]"
+
+// secrets are the values registered with AddSecrets, longest first so that a secret
+// containing another is replaced whole.
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecrets registers values, such as API tokens and webhook URLs, that must never
+// appear in logs. Every logger replaces them with Redacted. Values shorter than four
+// characters are ignored, as redacting them would garble unrelated text.
+func AddSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < 4 || contains(secrets, v) {
+			continue
+		}
+		secrets = append(secrets, v)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Patterns of credentials that are redacted even when they were never registered.
+var (
+	bearerPattern   = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	userinfoPattern = regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`)
+	webhookPattern  = regexp.MustCompile(`(/api/webhooks/\d+/)[A-Za-z0-9_-]+`)
+	secretKeys      = regexp.MustCompile(`(?i)(token|secret|password|passwd|authorization|api_?key)`)
+)
+
+// Redact returns s with registered secrets and well-known credential patterns
+// replaced by Redacted.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, v := range secrets {
+		if strings.Contains(s, v) {
+			s = strings.ReplaceAll(s, v, Redacted)
+		}
+	}
+	secretsMu.RUnlock()
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Redacted)
+	s = userinfoPattern.ReplaceAllString(s, "${1}"+Redacted+"@")
+	return webhookPattern.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// redactHandler redacts messages and string fields before passing records on. Fields
+// whose key names a credential ("token", "api_key", ...) become Redacted whatever
+// their value.
+type redactHandler struct {
+	next slog.Handler
+	caps *captures
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level) || h.caps.wants(level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	h.caps.record(r.Level, out.Message)
+	if !h.next.Enabled(ctx, r.Level) {
+		// Only a capture wanted this record
+		return nil
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	red := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		red[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(red), caps: h.caps}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), caps: h.caps}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch {
+	case v.Kind() == slog.KindGroup:
+		group := v.Group()
+		red := make([]any, len(group))
+		for i, ga := range group {
+			red[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, red...)
+	case secretKeys.MatchString(a.Key):
+		return slog.String(a.Key, Redacted)
+	case v.Kind() == slog.KindString, v.Kind() == slog.KindAny:
+		// Errors and other values are rendered to text, which may carry a secret
+		return slog.String(a.Key, Redact(v.String()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
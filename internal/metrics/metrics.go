@@ -0,0 +1,291 @@
+// Package metrics is a minimal Prometheus instrumentation library: counters, gauges
+// and histograms with labels, exposed in the text exposition format over HTTP, written
+// to a file for the node_exporter textfile collector, or pushed to a Pushgateway.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*family
+}
+
+// Default is the registry the New* functions register with.
+var Default = &Registry{}
+
+// family is a metric with all of its label combinations.
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // histograms: per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+func (r *Registry) register(f *family) *family {
+	f.series = map[string]*series{}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, f)
+	return f
+}
+
+// droppedSamples counts samples recorded with the wrong number of label values. Such a
+// sample is a programming error, but not one worth crashing a sync over.
+var droppedSamples = NewCounter("gocfgw_metrics_dropped_samples_total",
+	"Samples dropped because they had the wrong number of label values.", "metric")
+
+// get returns the series for the label values, or nil, after counting the sample as
+// dropped, if their number does not match the family's labels.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		if f != droppedSamples.f {
+			droppedSamples.Inc(f.name)
+		}
+		return nil
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// reset drops all label combinations.
+func (f *family) reset() {
+	f.mu.Lock()
+	f.series = map[string]*series{}
+	f.mu.Unlock()
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct{ f *family }
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{Default.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Add increases the counter for the label values by v, which must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if s := c.f.get(labelValues); s != nil {
+		s.value += v
+	}
+}
+
+// Inc increases the counter for the label values by one.
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ f *family }
+
+// NewGauge registers a gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{Default.register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// Set sets the gauge for the label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	if s := g.f.get(labelValues); s != nil {
+		s.value = v
+	}
+}
+
+// Reset drops all label combinations, for gauges describing the latest run only.
+func (g *Gauge) Reset() { g.f.reset() }
+
+// Histogram counts observations in buckets.
+type Histogram struct{ f *family }
+
+// NewHistogram registers a histogram with the given upper bucket bounds and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{Default.register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: b})}
+}
+
+// Observe records v for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	if s == nil {
+		return
+	}
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != "histogram" {
+				fmt.Fprintf(&buf, "%s%s %s\n", f.name, labelString(f.labels, s.labelValues, "", ""), formatFloat(s.value))
+				continue
+			}
+			var cum uint64
+			for i, b := range f.buckets {
+				cum += s.counts[i]
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", formatFloat(b)), cum)
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labelValues, "", ""), formatFloat(s.sum))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", f.name, labelString(f.labels, s.labelValues, "", ""), s.count)
+		}
+		f.mu.Unlock()
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Default.WriteText(w)
+	})
+}
+
+// WriteFile atomically writes the Default registry to path, for the node_exporter
+// textfile collector (the file name must end in .prom).
+func WriteFile(path string) error {
+	var buf bytes.Buffer
+	if err := Default.WriteText(&buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Push replaces the metrics of job on the Pushgateway at gatewayURL with the Default
+// registry.
+func Push(ctx context.Context, client *http.Client, gatewayURL, job string) error {
+	var buf bytes.Buffer
+	if err := Default.WriteText(&buf); err != nil {
+		return err
+	}
+	u := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + pathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway: http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, escapeLabel(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelEscaper escapes a label value for the text exposition format, which only knows
+// \\, \" and \n; Go's %q escapes would be read back literally.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// pathEscape escapes a Pushgateway grouping value.
+func pathEscape(s string) string {
+	return strings.NewReplacer("/", "%2F", " ", "%20").Replace(s)
+}
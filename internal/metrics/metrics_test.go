@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLabelEscaping(t *testing.T) {
+	for _, tc := range []struct{ value, want string }{
+		{`plain`, `plain`},
+		{`C:\lists\hosts.txt`, `C:\\lists\\hosts.txt`},
+		{`say "hi"`, `say \"hi\"`},
+		{"two\nlines", `two\nlines`},
+		// Anything else is valid UTF-8 in a label value and written as is
+		{"tab\there ünïcode", "tab\there ünïcode"},
+	} {
+		if got, want := labelString([]string{"source"}, []string{tc.value}, "", ""), `{source="`+tc.want+`"}`; got != want {
+			t.Errorf("labels for %q = %s, want %s", tc.value, got, want)
+		}
+	}
+}
+
+func TestWriteTextLabels(t *testing.T) {
+	h := NewHistogram("test_escape_seconds", "Escaping test.", []float64{1}, "source")
+	h.Observe(0.5, `a"b\c`)
+	var buf bytes.Buffer
+	if err := Default.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`test_escape_seconds_bucket{source="a\"b\\c",le="1"} 1`,
+		`test_escape_seconds_bucket{source="a\"b\\c",le="+Inf"} 1`,
+		`test_escape_seconds_count{source="a\"b\\c"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("output lacks %s:\n%s", want, buf.String())
+		}
+	}
+}
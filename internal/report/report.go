@@ -0,0 +1,366 @@
+// Package report builds the machine-readable summary of a sync: what every source
+// contributed, what changed in Cloudflare, how many API calls it took and what went
+// wrong. It is written as JSON and Markdown, and appended to the GitHub Actions job
+// summary when running there.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/cf"
+	"github.com/galpt/go-cfgw/internal/downloader"
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/worker"
+)
+
+// Version is the current report format.
+const Version = 1
+
+// Source is what one source contributed.
+type Source struct {
+	URL        string         `json:"url"`
+	Kind       string         `json:"kind"`
+	Lines      int            `json:"lines"`    // non-empty, non-comment lines fetched
+	Accepted   int            `json:"accepted"` // lines that produced a valid domain
+	Duplicates int            `json:"duplicates"`
+	Added      int            `json:"added"` // new domains not listed by an earlier source
+	Rejected   int            `json:"rejected"`
+	RejectedBy map[string]int `json:"rejected_by,omitempty"`
+	Status     string         `json:"status"` // "fresh", "not-modified", "stale", "mirror" or "skipped"
+	Error      string         `json:"error,omitempty"`
+	Seconds    float64        `json:"seconds"`
+}
+
+// Change is a list or rule go-cfgw created, updated, left unchanged or deleted.
+type Change struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	ID     string `json:"id,omitempty"`
+	Count  int    `json:"count,omitempty"`
+}
+
+// Counts tallies changes by action.
+type Counts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+// API describes the Cloudflare API calls of the sync.
+type API struct {
+	Requests        int            `json:"requests"`
+	ByMethod        map[string]int `json:"by_method"`
+	ByStatus        map[string]int `json:"by_status"`
+	RateLimited     int            `json:"rate_limited"`
+	BackoffSeconds  float64        `json:"backoff_seconds"`
+	ThrottleSeconds float64        `json:"throttle_seconds"` // waiting for the client-side rate limiter
+}
+
+// Timings are the durations of the sync's phases in seconds.
+type Timings struct {
+	Total      float64 `json:"total"`
+	Download   float64 `json:"download"`
+	Cloudflare float64 `json:"cloudflare"`
+}
+
+// Report is the summary of one sync.
+type Report struct {
+	Version    int       `json:"version"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+	Result     string    `json:"result"` // "success" or "error"
+	Error      string    `json:"error,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"` // trace of the sync, when tracing is enabled
+	Timings    Timings   `json:"timings_seconds"`
+
+	Sources   []Source `json:"sources"`
+	Allow     int      `json:"allow"` // entries pushed, after truncation
+	Block     int      `json:"block"`
+	Truncated int      `json:"truncated,omitempty"` // entries dropped to fit the account
+
+	Lists     []Change `json:"lists"`
+	Rules     []Change `json:"rules"`
+	ListCount Counts   `json:"list_changes"`
+	RuleCount Counts   `json:"rule_changes"`
+
+	API      API      `json:"api"`
+	Warnings []string `json:"warnings"`
+}
+
+// Input is what a sync knows when it ends. Result and Summary are nil if the sync
+// failed before producing them.
+type Input struct {
+	StartedAt  time.Time
+	DryRun     bool
+	TraceID    string
+	Result     *downloader.Result
+	Summary    *worker.Summary
+	API        cf.APIStats
+	Warnings   []string
+	Download   time.Duration
+	Cloudflare time.Duration
+	Err        error
+}
+
+// Build assembles the report of a sync.
+func Build(in Input) *Report {
+	now := time.Now().UTC()
+	r := &Report{
+		Version:    Version,
+		StartedAt:  in.StartedAt.UTC(),
+		FinishedAt: now,
+		DryRun:     in.DryRun,
+		Result:     "success",
+		TraceID:    in.TraceID,
+		Timings: Timings{
+			Total:      seconds(now.Sub(in.StartedAt)),
+			Download:   seconds(in.Download),
+			Cloudflare: seconds(in.Cloudflare),
+		},
+		Sources:  []Source{},
+		Lists:    []Change{},
+		Rules:    []Change{},
+		Warnings: append([]string{}, in.Warnings...),
+		API: API{
+			Requests:        in.API.Requests,
+			ByMethod:        nonNil(in.API.ByMethod),
+			ByStatus:        nonNil(in.API.ByStatus),
+			RateLimited:     in.API.RateLimited,
+			BackoffSeconds:  seconds(in.API.Backoff),
+			ThrottleSeconds: seconds(in.API.Throttled),
+		},
+	}
+	if in.Err != nil {
+		r.Result, r.Error = "error", logging.Redact(in.Err.Error())
+	}
+
+	if res := in.Result; res != nil {
+		for _, s := range res.Sources {
+			r.Sources = append(r.Sources, Source{
+				URL:        logging.Redact(s.URL),
+				Kind:       s.Kind,
+				Lines:      s.Lines,
+				Accepted:   s.Accepted,
+				Duplicates: s.Duplicates(),
+				Added:      s.Added,
+				Rejected:   s.RejectedTotal(),
+				RejectedBy: s.Rejected,
+				Status:     status(s),
+				Error:      logging.Redact(s.Error),
+				Seconds:    seconds(s.Duration),
+			})
+		}
+		r.Allow, r.Block = len(res.Allow), len(res.Block)
+	}
+
+	if sum := in.Summary; sum != nil {
+		if sum.Truncation != nil {
+			r.Truncated = len(sum.Truncation.Dropped)
+		}
+		for _, c := range sum.Changes {
+			change := Change{Action: c.Action, Name: c.Name, ID: c.ID, Count: c.Count}
+			if c.Kind == "rule" {
+				r.Rules = append(r.Rules, change)
+				r.RuleCount.add(c.Action)
+			} else {
+				r.Lists = append(r.Lists, change)
+				r.ListCount.add(c.Action)
+			}
+		}
+	}
+	return r
+}
+
+func (c *Counts) add(action string) {
+	switch action {
+	case "created":
+		c.Created++
+	case "updated":
+		c.Updated++
+	case "unchanged":
+		c.Unchanged++
+	case "deleted":
+		c.Deleted++
+	}
+}
+
+func status(s *downloader.SourceReport) string {
+	switch {
+	case s.Skipped:
+		return "skipped"
+	case s.CacheStatus != "":
+		return s.CacheStatus
+	case s.Mirror != "":
+		return "mirror"
+	}
+	return "fresh"
+}
+
+func seconds(d time.Duration) float64 {
+	return float64(d.Round(time.Millisecond)) / float64(time.Second)
+}
+
+func nonNil(m map[string]int) map[string]int {
+	if m == nil {
+		return map[string]int{}
+	}
+	return m
+}
+
+// WriteJSON atomically writes the report to path.
+func (r *Report) WriteJSON(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(b, '\n'))
+}
+
+// WriteMarkdown atomically writes the Markdown rendering of the report to path.
+func (r *Report) WriteMarkdown(path string) error {
+	return writeFile(path, []byte(r.Markdown()))
+}
+
+// AppendStepSummary appends the Markdown rendering to the file named by
+// GITHUB_STEP_SUMMARY, so it shows on the workflow run's page. It does nothing outside
+// GitHub Actions.
+func (r *Report) AppendStepSummary() error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(r.Markdown()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Markdown renders the report for humans.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	title := "go-cfgw sync succeeded"
+	if r.Result != "success" {
+		title = "go-cfgw sync failed"
+	}
+	if r.DryRun {
+		title += " (dry-run)"
+	}
+	fmt.Fprintf(&b, "## %s\n\n", title)
+	if r.Error != "" {
+		fmt.Fprintf(&b, "**Error:** %s\n\n", mdEscape(r.Error))
+	}
+
+	b.WriteString("| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Started | %s |\n", r.StartedAt.Format(time.RFC3339))
+	if r.TraceID != "" {
+		fmt.Fprintf(&b, "| Trace | `%s` |\n", r.TraceID)
+	}
+	fmt.Fprintf(&b, "| Duration | %.1fs (download %.1fs, Cloudflare %.1fs) |\n", r.Timings.Total, r.Timings.Download, r.Timings.Cloudflare)
+	fmt.Fprintf(&b, "| Entries | %d blocked, %d allowed |\n", r.Block, r.Allow)
+	if r.Truncated > 0 {
+		fmt.Fprintf(&b, "| Truncated | %d entries dropped to fit the account |\n", r.Truncated)
+	}
+	fmt.Fprintf(&b, "| Lists | %s |\n", r.ListCount)
+	fmt.Fprintf(&b, "| Rules | %s |\n", r.RuleCount)
+	var statuses []string
+	for _, k := range sortedKeys(r.API.ByStatus) {
+		statuses = append(statuses, fmt.Sprintf("%s: %d", k, r.API.ByStatus[k]))
+	}
+	fmt.Fprintf(&b, "| API requests | %d", r.API.Requests)
+	if len(statuses) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(statuses, ", "))
+	}
+	fmt.Fprintf(&b, ", %d rate limited, %.1fs backoff, %.1fs throttled |\n\n", r.API.RateLimited, r.API.BackoffSeconds, r.API.ThrottleSeconds)
+
+	if len(r.Sources) > 0 {
+		b.WriteString("### Sources\n\n")
+		b.WriteString("| Source | Kind | Lines | Accepted | Duplicates | Added | Rejected | Status | Time |\n")
+		b.WriteString("|---|---|--:|--:|--:|--:|--:|---|--:|\n")
+		for _, s := range r.Sources {
+			status := s.Status
+			if s.Error != "" {
+				status += ": " + s.Error
+			}
+			fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %d | %d | %s | %.1fs |\n",
+				mdEscape(s.URL), s.Kind, s.Lines, s.Accepted, s.Duplicates, s.Added, s.Rejected, mdEscape(status), s.Seconds)
+		}
+		b.WriteString("\n")
+	}
+
+	var changes []string
+	for _, group := range []struct {
+		kind    string
+		changes []Change
+	}{{"list", r.Lists}, {"rule", r.Rules}} {
+		for _, c := range group.changes {
+			if c.Action == "unchanged" {
+				continue
+			}
+			line := fmt.Sprintf("- %s %s **%s**", c.Action, group.kind, mdEscape(c.Name))
+			if c.ID != "" {
+				line += fmt.Sprintf(" (`%s`)", c.ID)
+			}
+			if c.Count > 0 {
+				line += fmt.Sprintf(", %d items", c.Count)
+			}
+			changes = append(changes, line)
+		}
+	}
+	if len(changes) > 0 {
+		b.WriteString("### Changes\n\n")
+		b.WriteString(strings.Join(changes, "\n"))
+		b.WriteString("\n\n")
+	}
+
+	if len(r.Warnings) > 0 {
+		b.WriteString("### Warnings\n\n")
+		for _, w := range r.Warnings {
+			fmt.Fprintf(&b, "- %s\n", mdEscape(strings.TrimSpace(w)))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d deleted", c.Created, c.Updated, c.Unchanged, c.Deleted)
+}
+
+// mdEscape keeps text from breaking table cells and list items.
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(s)
+}
+
+// sortedKeys returns the keys of m in order, for stable output.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
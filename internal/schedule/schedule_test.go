@@ -0,0 +1,135 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // DST tests need Europe/Berlin wherever they run
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"30s",
+		"@every 10s",
+		"@fortnightly",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 1, 14, 10, 17, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		want []string // following activations, in UTC
+	}{
+		{"1h", []string{"2026-01-14 11:17:30", "2026-01-14 12:17:30"}},
+		{"@every 90m", []string{"2026-01-14 11:47:30"}},
+		{"@hourly", []string{"2026-01-14 11:00", "2026-01-14 12:00"}},
+		{"@daily", []string{"2026-01-15 00:00", "2026-01-16 00:00"}},
+		{"@midnight", []string{"2026-01-15 00:00"}},
+		{"@weekly", []string{"2026-01-18 00:00", "2026-01-25 00:00"}},
+		{"@monthly", []string{"2026-02-01 00:00", "2026-03-01 00:00"}},
+		{"@yearly", []string{"2027-01-01 00:00"}},
+		{"@annually", []string{"2027-01-01 00:00"}},
+		{"*/20 * * * *", []string{"2026-01-14 10:20", "2026-01-14 10:40", "2026-01-14 11:00"}},
+		{"15 */6 * * *", []string{"2026-01-14 12:15", "2026-01-14 18:15", "2026-01-15 00:15"}},
+		{"0 9-17/4 * * *", []string{"2026-01-14 13:00", "2026-01-14 17:00", "2026-01-15 09:00"}},
+		{"5/20 * * * *", []string{"2026-01-14 10:25", "2026-01-14 10:45", "2026-01-14 11:05"}},
+		{"0,30 8,20 * * *", []string{"2026-01-14 20:00", "2026-01-14 20:30", "2026-01-15 08:00"}},
+		{"0 0 * jan-feb/1 mon-fri", []string{"2026-01-15 00:00", "2026-01-16 00:00", "2026-01-19 00:00"}},
+		{"0 12 * * SUN", []string{"2026-01-18 12:00"}},
+		{"0 12 * * 7", []string{"2026-01-18 12:00"}},
+		{"0 0 1 MAR *", []string{"2026-03-01 00:00", "2027-03-01 00:00"}},
+		// Restricted day of month and day of week match when either does
+		{"0 0 20 * fri", []string{"2026-01-16 00:00", "2026-01-20 00:00", "2026-01-23 00:00"}},
+		// With either one unrestricted, both have to match
+		{"0 0 */10 * fri", []string{"2026-05-01 00:00", "2026-07-31 00:00", "2026-08-21 00:00"}},
+		{"0 0 13 * *", []string{"2026-02-13 00:00", "2026-03-13 00:00"}},
+		{"0 0 31 * *", []string{"2026-01-31 00:00", "2026-03-31 00:00", "2026-05-31 00:00"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00"}},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.spec, err)
+			continue
+		}
+		next := from
+		for _, w := range tc.want {
+			next = s.Next(next)
+			if got := next.UTC().Format("2006-01-02 15:04:05"); got != w && got != w+":00" {
+				t.Errorf("%q: next %s, want %s", tc.spec, got, w)
+				break
+			}
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("February 30 scheduled at %v, want never", next)
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04 MST", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	for _, tc := range []struct {
+		name string
+		spec string
+		from string
+		want []string
+	}{
+		// Clocks go from 02:00 CET to 03:00 CEST on March 29, 2026
+		{"skipped time", "30 2 * * *", "2026-03-28 12:00 CET", []string{"2026-03-30 02:30 CEST"}},
+		{"daily across spring", "0 12 * * *", "2026-03-28 12:00 CET", []string{"2026-03-29 12:00 CEST"}},
+		{"hourly across spring", "0 * * * *", "2026-03-29 01:30 CET", []string{"2026-03-29 03:00 CEST", "2026-03-29 04:00 CEST"}},
+		// Clocks go from 03:00 CEST back to 02:00 CET on October 25, 2026
+		{"repeated time", "30 2 * * *", "2026-10-24 12:00 CEST", []string{"2026-10-25 02:30 CEST", "2026-10-26 02:30 CET"}},
+		{"hourly across autumn", "0 * * * *", "2026-10-25 01:30 CEST", []string{"2026-10-25 02:00 CEST", "2026-10-25 02:00 CET", "2026-10-25 03:00 CET"}},
+		{"daily across autumn", "0 12 * * *", "2026-10-24 12:00 CEST", []string{"2026-10-25 12:00 CET"}},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.spec, err)
+		}
+		next := at(tc.from)
+		for _, w := range tc.want {
+			next = s.Next(next)
+			if want := at(w); !next.Equal(want) {
+				t.Errorf("%s: next %v, want %v", tc.name, next, want)
+				break
+			}
+		}
+	}
+}
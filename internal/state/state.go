@@ -0,0 +1,181 @@
+// Package state records the Cloudflare resources go-cfgw manages, so runs can update
+// them by ID instead of finding them by name.
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Version is the current state file format.
+const Version = 1
+
+// List is a Cloudflare list owned by go-cfgw.
+type List struct {
+	Kind  string `json:"kind"` // "allow" or "block"
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Hash  string `json:"hash"` // Hash of the entries written to the list
+	Count int    `json:"count"`
+	// First and Last are the lowest and highest entry written to the list. Later runs
+	// keep these ranges, so a changed entry only rewrites the list covering it.
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+// Rule is a Gateway rule owned by go-cfgw.
+type Rule struct {
+	Key  string `json:"key"` // "dns" or "sni"
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	Hash string `json:"hash"` // Hash of the rule body last sent to Cloudflare
+
+	Traffic    string `json:"traffic,omitempty"`    // expression last sent to Cloudflare
+	Precedence int    `json:"precedence,omitempty"` // precedence Cloudflare reported after the last write
+}
+
+// Run describes the outcome of a sync.
+type Run struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+	Allow      int       `json:"allow"`
+	Block      int       `json:"block"`
+	Created    int       `json:"created"`
+	Updated    int       `json:"updated"`
+	Unchanged  int       `json:"unchanged"`
+	Deleted    int       `json:"deleted"`
+}
+
+// Limits are list limits Cloudflare reported in errors, so later runs size their
+// chunks to them from the start. Zero values were never reported. They only apply to
+// the rate plan they were reported for and expire after a while.
+type Limits struct {
+	MaxLists        int       `json:"max_lists,omitempty"`
+	MaxItemsPerList int       `json:"max_items_per_list,omitempty"`
+	Plan            string    `json:"plan,omitempty"` // Zero Trust rate plan at the time, empty if unknown
+	LearnedAt       time.Time `json:"learned_at"`
+}
+
+// State is the content of the state file.
+type State struct {
+	Version   int     `json:"version"`
+	AccountID string  `json:"account_id"`
+	Lists     []List  `json:"lists"`
+	Rules     []Rule  `json:"rules"`
+	Limits    *Limits `json:"limits,omitempty"`
+	LastRun   *Run    `json:"last_run,omitempty"`
+}
+
+// Load reads the state file at path. A missing file yields an empty state.
+func Load(path string) (*State, error) {
+	st := &State{Version: Version}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if st.Version > Version {
+		return nil, fmt.Errorf("%s was written by a newer go-cfgw (format %d)", path, st.Version)
+	}
+	return st, nil
+}
+
+// Save writes the state to path atomically.
+func (st *State) Save(path string) error {
+	st.Version = Version
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Empty reports whether the state tracks no resources.
+func (st *State) Empty() bool { return len(st.Lists) == 0 && len(st.Rules) == 0 }
+
+// List returns the tracked list of kind with name, or nil.
+func (st *State) List(kind, name string) *List {
+	for i := range st.Lists {
+		if st.Lists[i].Kind == kind && st.Lists[i].Name == name {
+			return &st.Lists[i]
+		}
+	}
+	return nil
+}
+
+// SetList records a list, replacing the one with the same kind and name.
+func (st *State) SetList(l List) {
+	if old := st.List(l.Kind, l.Name); old != nil {
+		*old = l
+		return
+	}
+	st.Lists = append(st.Lists, l)
+}
+
+// RemoveList stops tracking the list with id.
+func (st *State) RemoveList(id string) {
+	out := st.Lists[:0]
+	for _, l := range st.Lists {
+		if l.ID != id {
+			out = append(out, l)
+		}
+	}
+	st.Lists = out
+}
+
+// Rule returns the tracked rule with key, or nil.
+func (st *State) Rule(key string) *Rule {
+	for i := range st.Rules {
+		if st.Rules[i].Key == key {
+			return &st.Rules[i]
+		}
+	}
+	return nil
+}
+
+// SetRule records a rule, replacing the one with the same key.
+func (st *State) SetRule(r Rule) {
+	if old := st.Rule(r.Key); old != nil {
+		*old = r
+		return
+	}
+	st.Rules = append(st.Rules, r)
+}
+
+// RemoveRule stops tracking the rule with key.
+func (st *State) RemoveRule(key string) {
+	out := st.Rules[:0]
+	for _, r := range st.Rules {
+		if r.Key != key {
+			out = append(out, r)
+		}
+	}
+	st.Rules = out
+}
+
+// Hash returns a stable digest of entries, used to detect changed chunks and rules.
+func Hash(entries ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,223 @@
+// Package tracing records spans of a sync and exports them in the OpenTelemetry
+// protocol (OTLP) JSON encoding, over HTTP to a collector or to a local file. It
+// covers what go-cfgw needs from OpenTelemetry without its dependencies: nested spans
+// carried in a context.Context, attributes, error status and batched export.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxSpans bounds the spans buffered between exports; later spans are dropped.
+const maxSpans = 20000
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+// Tracer buffers finished spans until Flush.
+type Tracer struct {
+	service  string
+	exporter Exporter
+
+	mu      sync.Mutex
+	spans   []SpanData
+	dropped int
+	closed  bool
+}
+
+// NewTracer returns a tracer exporting to e under the given service name.
+func NewTracer(service string, e Exporter) *Tracer {
+	return &Tracer{service: service, exporter: e}
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Tracer
+)
+
+// SetTracer installs t as the tracer used by Start; nil disables tracing.
+func SetTracer(t *Tracer) {
+	globalMu.Lock()
+	global = t
+	globalMu.Unlock()
+}
+
+func current() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// Flush exports the spans finished since the last flush.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	if err := t.exporter.Export(ctx, t.service, spans); err != nil {
+		return err
+	}
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d span(s) over the limit of %d", dropped, maxSpans)
+	}
+	return nil
+}
+
+// Shutdown flushes the remaining spans of a tracer that is being replaced. Spans ending
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	return t.Flush(ctx)
+}
+
+func (t *Tracer) record(s SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if len(t.spans) >= maxSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// Attr is a span attribute. Value is a string, bool, int64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(k, v string) Attr { return Attr{k, v} }
+
+// Int returns an integer attribute.
+func Int(k string, v int) Attr { return Attr{k, int64(v)} }
+
+// Bool returns a boolean attribute.
+func Bool(k string, v bool) Attr { return Attr{k, v} }
+
+// Float returns a floating point attribute.
+func Float(k string, v float64) Attr { return Attr{k, v} }
+
+// SpanData is a finished span.
+type SpanData struct {
+	TraceID, SpanID, ParentID string // hex encoded, ParentID empty for a root span
+	Name                      string
+	Kind                      Kind
+	Start, End                time.Time
+	Attrs                     []Attr
+	Error                     string // status message when the span failed
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+// Span kinds used by go-cfgw.
+const (
+	KindInternal Kind = 1
+	KindClient   Kind = 3
+)
+
+// Span is a span in progress. A nil *Span, returned while tracing is disabled, is
+// valid and does nothing.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+type ctxKey struct{}
+
+// Start begins a span as a child of the span in ctx, or as a new trace. End must be
+// called on the returned span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return StartKind(ctx, KindInternal, name, attrs...)
+}
+
+// StartKind is Start with an explicit span kind, such as KindClient for outgoing
+// requests.
+func StartKind(ctx context.Context, kind Kind, name string, attrs ...Attr) (context.Context, *Span) {
+	t := current()
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: time.Now(), SpanID: newID(8), Attrs: attrs}}
+	if parent, _ := ctx.Value(ctxKey{}).(*Span); parent != nil {
+		s.data.TraceID, s.data.ParentID = parent.data.TraceID, parent.data.SpanID
+	} else {
+		s.data.TraceID = newID(16)
+	}
+	return context.WithValue(ctx, ctxKey{}, s), s
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span. Calls after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.record(data)
+}
+
+// TraceID returns the hex trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.TraceID
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// memExporter keeps exported spans in memory.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memExporter) Export(_ context.Context, _ string, spans []SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+// trace records a sync span with a failed child span on t.
+func trace(t *Tracer) {
+	SetTracer(t)
+	defer SetTracer(nil)
+	ctx, root := Start(context.Background(), "sync", Bool("dry_run", true))
+	_, child := StartKind(ctx, KindClient, "fetch", String("url.full", "https://lists.example.com/hosts"), Int("attempt", 2), Float("ratio", 0.5))
+	child.RecordError(errors.New("http 503"))
+	child.End()
+	root.End()
+}
+
+// TestOTLPEncoding checks the request against the OTLP/HTTP JSON encoding of
+// opentelemetry-proto's ExportTraceServiceRequest: lowerCamelCase field names, hex
+// trace and span IDs, 64-bit integers as decimal strings and enums as numbers.
+func TestOTLPEncoding(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "k" {
+			t.Errorf("request %s %s with %v", r.Method, r.URL.Path, r.Header)
+		}
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	tr := NewTracer("go-cfgw-test", &OTLPExporter{URL: srv.URL + "/v1/traces", Headers: map[string]string{"X-Api-Key": "k"}})
+	trace(tr)
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want one resource and one scope:\n%s", body)
+	}
+	rs := req.ResourceSpans[0]
+	if got := rs.Resource.Attributes; len(got) != 1 || got[0]["key"] != "service.name" ||
+		got[0]["value"].(map[string]any)["stringValue"] != "go-cfgw-test" {
+		t.Errorf("resource attributes %v, want service.name", got)
+	}
+	if rs.ScopeSpans[0].Scope.Name == "" {
+		t.Error("scope without a name")
+	}
+
+	spans := map[string]map[string]any{}
+	for _, s := range rs.ScopeSpans[0].Spans {
+		spans[s["name"].(string)] = s
+	}
+	root, child := spans["sync"], spans["fetch"]
+	if root == nil || child == nil {
+		t.Fatalf("spans %v, want sync and fetch", spans)
+	}
+	traceID, spanID, nanos := regexp.MustCompile(`^[0-9a-f]{32}$`), regexp.MustCompile(`^[0-9a-f]{16}$`), regexp.MustCompile(`^[0-9]+$`)
+	for name, s := range spans {
+		for field, re := range map[string]*regexp.Regexp{"traceId": traceID, "spanId": spanID, "startTimeUnixNano": nanos, "endTimeUnixNano": nanos} {
+			if v, ok := s[field].(string); !ok || !re.MatchString(v) {
+				t.Errorf("%s: %s = %#v", name, field, s[field])
+			}
+		}
+	}
+	if child["traceId"] != root["traceId"] || child["parentSpanId"] != root["spanId"] {
+		t.Errorf("fetch is not a child of sync: %v", child)
+	}
+	if _, ok := root["parentSpanId"]; ok {
+		t.Errorf("root span has a parent: %v", root)
+	}
+	if root["kind"] != float64(1) || child["kind"] != float64(3) {
+		t.Errorf("kinds %v and %v, want 1 (internal) and 3 (client)", root["kind"], child["kind"])
+	}
+	if status := child["status"].(map[string]any); status["code"] != float64(2) || status["message"] != "http 503" {
+		t.Errorf("fetch status %v, want code 2 with the error", status)
+	}
+	if status := root["status"].(map[string]any); len(status) != 0 {
+		t.Errorf("sync status %v, want unset", status)
+	}
+
+	values := map[string]map[string]any{}
+	for _, a := range child["attributes"].([]any) {
+		kv := a.(map[string]any)
+		values[kv["key"].(string)] = kv["value"].(map[string]any)
+	}
+	for _, want := range []struct {
+		key, field string
+		value      any
+	}{
+		{"url.full", "stringValue", "https://lists.example.com/hosts"},
+		{"attempt", "intValue", "2"},
+		{"ratio", "doubleValue", 0.5},
+	} {
+		if got := values[want.key]; len(got) != 1 || got[want.field] != want.value {
+			t.Errorf("attribute %s = %v, want %s %v", want.key, got, want.field, want.value)
+		}
+	}
+	if got := root["attributes"].([]any)[0].(map[string]any)["value"]; got.(map[string]any)["boolValue"] != true {
+		t.Errorf("dry_run = %v, want boolValue true", got)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	e := &memExporter{}
+	tr := NewTracer("go-cfgw-test", e)
+	trace(tr)
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if len(e.spans) != 2 {
+		t.Fatalf("exported %d spans on shutdown, want 2", len(e.spans))
+	}
+	trace(tr)
+	if err := tr.Flush(context.Background()); err != nil || len(e.spans) != 2 {
+		t.Errorf("after shutdown: %v, %d spans exported, want the new ones dropped", err, len(e.spans))
+	}
+}
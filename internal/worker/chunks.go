@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/galpt/go-cfgw/internal/index"
+	"github.com/galpt/go-cfgw/internal/state"
+)
+
+// chunkRange is a tracked list of the previous run and the range of entries it held.
+type chunkRange struct {
+	name        string
+	first, last string
+}
+
+// planChunks splits sorted items into lists of at most size entries, using no more
+// than maxLists lists at any point of the sync.
+//
+// The lists tracked for kind (prev) keep the ranges of entries they held, so an added
+// or removed entry only changes the list covering it instead of shifting every later
+// chunk. A list that outgrows size is split, and one left empty becomes obsolete. When
+// prev has lists without a recorded range, or keeping the ranges would need too many
+// lists, the items are packed anew, reusing the tracked names in order.
+func planChunks(kind, baseName string, items []string, size, maxLists int, prev []state.List) ([]chunk, error) {
+	// Safety check: ensure chunk size is valid
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", size)
+	}
+	start := 1
+	var ranges []chunkRange
+	for _, l := range prev {
+		start = max(start, chunkNumber(l.Name)+1)
+		ranges = append(ranges, chunkRange{name: l.Name, first: l.First, last: l.Last})
+	}
+	next := start
+	fresh := func() string {
+		name := fmt.Sprintf("%s - Chunk %d", baseName, next)
+		next++
+		return name
+	}
+
+	var parts [][]string
+	var names []string
+	if keep := keptRanges(ranges); keep != nil {
+		for i, seg := range splitByRanges(items, keep) {
+			for j, part := range splitEven(seg, size) {
+				parts = append(parts, part)
+				if j == 0 {
+					names = append(names, keep[i].name)
+				} else {
+					names = append(names, fresh())
+				}
+			}
+		}
+		// Lists that become obsolete are only deleted after the others were written
+		if created := len(names) - countReused(names, ranges); len(ranges)+created > maxLists {
+			parts = nil
+		}
+	}
+	if parts == nil {
+		// Reuse the tracked names lowest number first, so repacking keeps the first chunks
+		sort.Slice(ranges, func(i, j int) bool { return chunkNumber(ranges[i].name) < chunkNumber(ranges[j].name) })
+		parts = pack(items, size, maxLists)
+		names, next = nil, start
+		for i := range parts {
+			if i < len(ranges) {
+				names = append(names, ranges[i].name)
+			} else {
+				names = append(names, fresh())
+			}
+		}
+	}
+
+	chunks := make([]chunk, 0, len(parts))
+	for i, part := range parts {
+		chunks = append(chunks, chunk{
+			Chunk: index.Chunk{Kind: kind, Name: names[i], First: part[0], Last: part[len(part)-1], Count: len(part)},
+			items: part,
+			hash:  state.Hash(part...),
+		})
+	}
+	return chunks, nil
+}
+
+// keptRanges returns ranges sorted by their first entry, or nil if there are none or
+// any of them has no recorded range.
+func keptRanges(ranges []chunkRange) []chunkRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	out := append([]chunkRange(nil), ranges...)
+	for _, r := range out {
+		if r.first == "" || r.last == "" {
+			return nil
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].first < out[j].first })
+	return out
+}
+
+// splitByRanges assigns each sorted item to the last range starting at or before it,
+// and items before the first range to the first one.
+func splitByRanges(items []string, ranges []chunkRange) [][]string {
+	segs := make([][]string, len(ranges))
+	r := 0
+	for _, v := range items {
+		for r+1 < len(ranges) && v >= ranges[r+1].first {
+			r++
+		}
+		segs[r] = append(segs[r], v)
+	}
+	return segs
+}
+
+// splitEven returns items as is when they fit one list of size, nothing when empty, and
+// otherwise even parts that leave room for growth.
+func splitEven(items []string, size int) [][]string {
+	if len(items) == 0 {
+		return nil
+	}
+	if len(items) <= size {
+		return [][]string{items}
+	}
+	fill := size - size/10
+	return evenParts(items, (len(items)+fill-1)/fill)
+}
+
+// pack splits items into lists filled to nine tenths of size, so later additions
+// rarely split a list, or into full lists if that would take more than maxLists.
+func pack(items []string, size, maxLists int) [][]string {
+	fill := size - size/10
+	if (len(items)+fill-1)/fill > maxLists {
+		fill = size
+	}
+	var parts [][]string
+	for start := 0; start < len(items); start += fill {
+		parts = append(parts, items[start:min(start+fill, len(items))])
+	}
+	return parts
+}
+
+// evenParts splits items into n parts whose sizes differ by at most one.
+func evenParts(items []string, n int) [][]string {
+	parts := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		parts = append(parts, items[i*len(items)/n:(i+1)*len(items)/n])
+	}
+	return parts
+}
+
+// countReused returns how many of names are already tracked in ranges.
+func countReused(names []string, ranges []chunkRange) int {
+	tracked := map[string]bool{}
+	for _, r := range ranges {
+		tracked[r.name] = true
+	}
+	n := 0
+	for _, name := range names {
+		if tracked[name] {
+			n++
+		}
+	}
+	return n
+}
+
+// chunkNumber returns the number of a chunk named like "Go-CFGW Block List - Chunk 3",
+// or 0.
+func chunkNumber(name string) int {
+	i := strings.LastIndex(name, " - Chunk ")
+	if i < 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(name[i+len(" - Chunk "):])
+	return n
+}
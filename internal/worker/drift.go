@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/galpt/go-cfgw/internal/cf"
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/state"
+)
+
+// Drift check modes accepted in config.Config.DriftCheck.
+const (
+	DriftOff   = "off"
+	DriftQuick = "quick" // names, item counts and rules
+	DriftFull  = "full"  // also the content hash of every list, which fetches all items
+)
+
+// Drift actions accepted in config.Config.DriftAction.
+const (
+	// DriftRepair rewrites drifted lists and rules during the sync.
+	DriftRepair = "repair"
+	// DriftAbort fails the sync before changing anything.
+	DriftAbort = "abort"
+)
+
+// Drift is a difference between a resource go-cfgw manages and what the state file
+// says it last wrote.
+type Drift struct {
+	Kind    string // "list" or "rule"
+	Key     string // list name, or rule key ("dns", "sni")
+	Name    string
+	ID      string
+	Problem string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s (%s): %s", d.Kind, d.Name, d.ID, d.Problem)
+}
+
+// Drift compares the live lists and rules of the account to the state file. It changes
+// nothing; run a sync with DriftRepair to fix what it finds.
+func (w *Worker) Drift(ctx context.Context, cfg *config.Config, deep bool) ([]Drift, error) {
+	st := w.loadState(cfg)
+	if st.Empty() {
+		return nil, fmt.Errorf("no state recorded in %q, run a sync first", cfg.StateFile)
+	}
+	client := w.client(cfg)
+	lists, err := client.Lists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get lists: %w", err)
+	}
+	rules, err := client.Rules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get rules: %w", err)
+	}
+	s := &reconciler{client: client, st: st, lists: map[string]cf.List{}, rules: map[string]cf.Rule{}}
+	for _, l := range lists {
+		s.lists[l.ID] = l
+	}
+	for _, r := range rules {
+		s.rules[r.ID] = r
+	}
+	return w.detectDrift(ctx, s, deep)
+}
+
+// detectDrift compares the remote resources in s with its state.
+func (w *Worker) detectDrift(ctx context.Context, s *reconciler, deep bool) ([]Drift, error) {
+	var drifts []Drift
+	for _, l := range s.st.Lists {
+		d := Drift{Kind: "list", Key: l.Name, Name: l.Name, ID: l.ID}
+		remote, ok := s.lists[l.ID]
+		switch {
+		case !ok:
+			d.Problem = "deleted in Cloudflare"
+		case remote.Name != l.Name:
+			d.Problem = fmt.Sprintf("renamed to %q", remote.Name)
+		case remote.Count != l.Count:
+			d.Problem = fmt.Sprintf("has %d items, expected %d", remote.Count, l.Count)
+		case deep:
+			items, err := s.client.ListItems(ctx, l.ID)
+			if err != nil {
+				return nil, fmt.Errorf("get items of list %s: %w", l.Name, err)
+			}
+			sort.Strings(items)
+			if state.Hash(items...) != l.Hash {
+				d.Problem = "items were edited"
+			}
+		}
+		if d.Problem != "" {
+			drifts = append(drifts, d)
+		}
+	}
+
+	for _, r := range s.st.Rules {
+		d := Drift{Kind: "rule", Key: r.Key, Name: r.Name, ID: r.ID}
+		remote, ok := s.rules[r.ID]
+		switch {
+		case !ok:
+			d.Problem = "deleted in Cloudflare"
+		case !remote.Enabled:
+			d.Problem = "disabled"
+		case r.Traffic != "" && remote.Traffic != r.Traffic:
+			d.Problem = "expression was edited"
+		case r.Precedence > 0 && remote.Precedence != r.Precedence:
+			d.Problem = fmt.Sprintf("precedence changed from %d to %d", r.Precedence, remote.Precedence)
+		case remote.Name != r.Name:
+			d.Problem = fmt.Sprintf("renamed to %q", remote.Name)
+		}
+		if d.Problem != "" {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts, nil
+}
+
+// invalidate clears the recorded hashes of drifted resources, so the sync rewrites them.
+func invalidate(st *state.State, drifts []Drift) {
+	for _, d := range drifts {
+		switch d.Kind {
+		case "list":
+			for i := range st.Lists {
+				if st.Lists[i].ID == d.ID {
+					st.Lists[i].Hash = ""
+				}
+			}
+		case "rule":
+			if r := st.Rule(d.Key); r != nil {
+				r.Hash = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package worker
+
+import "github.com/galpt/go-cfgw/internal/metrics"
+
+var (
+	listsChanged = metrics.NewCounter("gocfgw_lists_changed_total",
+		"Cloudflare lists created, updated or deleted by syncs.", "action")
+	syncRuns = metrics.NewCounter("gocfgw_sync_runs_total",
+		"Completed syncs by result (\"success\" or \"error\").", "result")
+	syncDuration = metrics.NewGauge("gocfgw_sync_duration_seconds",
+		"Duration of the last sync against Cloudflare.")
+	lastSuccess = metrics.NewGauge("gocfgw_last_success_timestamp_seconds",
+		"Unix time of the last successful sync.")
+	entries = metrics.NewGauge("gocfgw_entries",
+		"Entries pushed to Cloudflare by the last sync, after truncation.", "kind")
+)
@@ -2,19 +2,28 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/galpt/go-cfgw/internal/cf"
 	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/downloader"
+	"github.com/galpt/go-cfgw/internal/index"
 	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/state"
+	"github.com/galpt/go-cfgw/internal/tracing"
 )
 
 type Options struct {
 	Logger *logging.Logger
 	DryRun bool
+	// Client is reused across runs when set; otherwise each run creates its own.
+	Client *cf.Client
 }
 
 type Worker struct {
@@ -23,175 +32,736 @@ type Worker struct {
 
 func New(opts Options) *Worker { return &Worker{opts: opts} }
 
-// Run orchestrates updating Cloudflare lists and rules.
-func (w *Worker) Run(ctx context.Context, cfg *config.Config, allow []string, block []string) error {
-	client := cf.NewClient(cfg, w.opts.Logger)
+// Summary describes what a run wrote to Cloudflare.
+type Summary struct {
+	Chunks     []index.Chunk
+	Truncation *downloader.Truncation // nil if nothing was dropped
 
-	// Check total item limit
-	totalItems := len(allow) + len(block)
-	if totalItems > cfg.ListItemLimit {
-		w.opts.Logger.Infof("WARNING: Total items (%d) exceeds CLOUDFLARE_LIST_ITEM_LIMIT (%d)", totalItems, cfg.ListItemLimit)
-		w.opts.Logger.Infof("Proceeding anyway, but you may hit Cloudflare account limits")
-	}
+	// Lists and rules created, updated, left unchanged and deleted
+	Created, Updated, Unchanged, Deleted int
+	// Changes lists every list and rule handled, in order. In dry-run it holds the
+	// planned changes, which the counters above leave out.
+	Changes []Change
+}
 
-	// Step 1: Clean up all old rules first (both CGPS and Go-CFGW)
-	w.opts.Logger.Infof("Cleaning up old rules...")
-	if err := client.DeleteAllOldRules(ctx); err != nil {
-		return fmt.Errorf("cleanup old rules: %w", err)
-	}
+// Change is an action taken on a list or rule.
+type Change struct {
+	Kind   string // "list" or "rule"
+	Action string // "created", "updated", "unchanged" or "deleted"
+	Name   string
+	ID     string // empty for lists that a dry-run would create
+	Count  int    // items, for lists
+}
 
-	// Step 2: Clean up all old lists (both CGPS and Go-CFGW)
-	w.opts.Logger.Infof("Cleaning up old lists...")
-	if err := client.DeleteAllOldLists(ctx); err != nil {
-		return fmt.Errorf("cleanup old lists: %w", err)
-	}
+func (s *Summary) record(kind, action, name, id string, count int) {
+	s.Changes = append(s.Changes, Change{Kind: kind, Action: action, Name: name, ID: id, Count: count})
+}
 
-	// Brief pause to let API settle after deletions
-	time.Sleep(2 * time.Second)
+// settleDelay is how long the legacy cleanup waits after its deletions. Tests against
+// the fake API shorten it.
+var settleDelay = 2 * time.Second
 
-	// Step 3: Create new blocklist chunks
-	var createdListIDs []string
-	if len(block) > 0 {
-		w.opts.Logger.Infof("Creating blocklists with %d total entries...", len(block))
-		ids, err := w.createListsInChunks(ctx, client, cfg, "Go-CFGW Block List", block)
-		if err != nil {
-			return fmt.Errorf("create block lists: %w", err)
+// Names of the rules go-cfgw manages, keyed like in the state file.
+var ruleNames = map[string]string{
+	"dns": "Go-CFGW Filter Lists",
+	"sni": "Go-CFGW Filter Lists - SNI Based Filtering",
+}
+
+// chunk is a list go-cfgw wants to exist after the run.
+type chunk struct {
+	index.Chunk
+	items []string
+	hash  string
+}
+
+// reconciler holds what a run needs while bringing Cloudflare in line with the desired lists.
+type reconciler struct {
+	client *cf.Client
+	st     *state.State
+	save   func()
+	lists  map[string]cf.List // remote lists by ID
+	rules  map[string]cf.Rule // remote rules by ID
+	sum    *Summary
+}
+
+// Run orchestrates updating Cloudflare lists and rules. The summary is returned even
+// when the run fails part way, covering the lists created so far.
+//
+// Resources are tracked by ID in the state file. Unchanged lists are left alone,
+// changed ones are updated in place, rules are pointed at the new set and only then are
+// obsolete lists deleted. Without state (first run, or STATE_FILE=off) legacy resources
+// are found by their exact names, deleted and recreated.
+func (w *Worker) Run(ctx context.Context, cfg *config.Config, res *downloader.Result) (*Summary, error) {
+	ctx, span := tracing.Start(ctx, "cloudflare sync", tracing.Bool("dry_run", w.opts.DryRun))
+	defer span.End()
+	client := w.client(cfg)
+	sum := &Summary{}
+	run := &state.Run{StartedAt: time.Now().UTC()}
+
+	st := w.loadState(cfg)
+	save := func() {
+		if w.opts.DryRun || cfg.StateFile == "" {
+			return
+		}
+		if err := st.Save(cfg.StateFile); err != nil {
+			w.opts.Logger.Warnf("save state: %v", err)
 		}
-		createdListIDs = append(createdListIDs, ids...)
 	}
 
-	// Step 4: Create allowlist chunks if any
-	if len(allow) > 0 {
-		w.opts.Logger.Infof("Creating allowlists with %d total entries...", len(allow))
-		ids, err := w.createListsInChunks(ctx, client, cfg, "Go-CFGW Allow List", allow)
-		if err != nil {
-			return fmt.Errorf("create allow lists: %w", err)
+	if st.Limits != nil {
+		client.Learn(cf.Limits{MaxLists: st.Limits.MaxLists, MaxItemsPerList: st.Limits.MaxItemsPerList, Plan: st.Limits.Plan, LearnedAt: st.Limits.LearnedAt})
+	}
+	err := w.run(ctx, client, cfg, res, st, save, sum)
+	// Expired limits and those of another plan were dropped by the client
+	st.Limits = nil
+	if l := client.Learned(); l.MaxLists > 0 || l.MaxItemsPerList > 0 {
+		st.Limits = &state.Limits{MaxLists: l.MaxLists, MaxItemsPerList: l.MaxItemsPerList, Plan: l.Plan, LearnedAt: l.LearnedAt}
+	}
+	span.RecordError(err)
+	span.SetAttributes(tracing.Int("created", sum.Created), tracing.Int("updated", sum.Updated), tracing.Int("unchanged", sum.Unchanged), tracing.Int("deleted", sum.Deleted))
+
+	run.FinishedAt = time.Now().UTC()
+	run.Allow, run.Block = len(res.Allow), len(res.Block)
+	run.Created, run.Updated, run.Unchanged, run.Deleted = sum.Created, sum.Updated, sum.Unchanged, sum.Deleted
+	if err != nil {
+		run.Error = err.Error()
+	}
+	st.LastRun = run
+	save()
+
+	syncDuration.Set(run.FinishedAt.Sub(run.StartedAt).Seconds())
+	if err != nil {
+		syncRuns.Inc("error")
+	} else {
+		syncRuns.Inc("success")
+		if !w.opts.DryRun {
+			lastSuccess.Set(float64(run.FinishedAt.Unix()))
 		}
-		createdListIDs = append(createdListIDs, ids...)
 	}
+	return sum, err
+}
 
-	// Step 5: Build wirefilter expression
-	if len(createdListIDs) == 0 {
-		w.opts.Logger.Infof("No lists created, skipping rule creation")
-		return nil
+func (w *Worker) run(ctx context.Context, client *cf.Client, cfg *config.Config, res *downloader.Result, st *state.State, save func(), sum *Summary) error {
+	remoteLists, err := client.Lists(ctx)
+	if err != nil {
+		return fmt.Errorf("get lists: %w", err)
 	}
+	legacy := st.Empty()
 
-	w.opts.Logger.Infof("Creating Gateway rule for %d list(s)...", len(createdListIDs))
+	// Size the run to the account and drop the lowest ranked entries so it fits
+	tracked := map[string]bool{}
+	for _, l := range st.Lists {
+		tracked[l.ID] = true
+	}
+	others := 0
+	var adopt []state.List
+	adopted := map[string]bool{}
+	for _, l := range remoteLists {
+		kind := chunkKind(l.Name)
+		switch {
+		case tracked[l.ID]:
+		case legacy && cf.IsManagedList(l.Name):
+			// Lists with legacy names are ours only when they are about to be cleaned up
+		case kind != "" && st.List(kind, l.Name) == nil && !adopted[kind+"\x00"+l.Name]:
+			// A chunk created by a run that failed before saving the state. Adopt it, so
+			// it is updated or deleted below instead of lingering forever.
+			adopted[kind+"\x00"+l.Name] = true
+			adopt = append(adopt, state.List{Kind: kind, Name: l.Name, ID: l.ID, Count: l.Count})
+		default:
+			if cf.IsManagedList(l.Name) {
+				w.opts.Logger.Warnf("List %s (%s) has a managed name but is not in the state file; leaving it alone, delete it in the dashboard if it is not needed", l.Name, l.ID)
+			}
+			others++
+		}
+	}
+	itemsPerList, lists := w.planLimits(ctx, client, cfg, others)
+	if t := res.Truncate(cfg.ListItemLimit, lists, itemsPerList); t != nil {
+		w.logTruncation(t)
+		sum.Truncation = t
+	}
+	allow, block := res.Allow, res.Block
+	entries.Set(float64(len(allow)), "allow")
+	entries.Set(float64(len(block)), "block")
+
+	s := &reconciler{client: client, st: st, save: save, lists: map[string]cf.List{}, rules: map[string]cf.Rule{}, sum: sum}
+	if legacy {
+		// Step 1: Clean up all old rules and lists (both CGPS and Go-CFGW) by name
+		if err := w.cleanupLegacy(ctx, client); err != nil {
+			return err
+		}
+	} else {
+		for _, l := range remoteLists {
+			s.lists[l.ID] = l
+		}
+		rules, err := client.Rules(ctx)
+		if err != nil {
+			return fmt.Errorf("get rules: %w", err)
+		}
+		for _, r := range rules {
+			s.rules[r.ID] = r
+		}
 
-	// Build wirefilter expression matching Node.js implementation
-	// Format: any(dns.domains[*] in $listID1) or any(dns.domains[*] in $listID2) or ...
-	// Use strings.Builder for efficient and safe string concatenation
-	var wirefilterBuilder strings.Builder
-	for i, id := range createdListIDs {
-		if i > 0 {
-			wirefilterBuilder.WriteString(" or ")
+		// Step 1: Find manual changes made in the dashboard since the last run
+		if cfg.DriftCheck != DriftOff {
+			dctx, span := tracing.Start(ctx, "drift check", tracing.String("mode", cfg.DriftCheck))
+			drifts, err := w.detectDrift(dctx, s, cfg.DriftCheck == DriftFull)
+			span.SetAttributes(tracing.Int("drifts", len(drifts)))
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				return fmt.Errorf("drift check: %w", err)
+			}
+			for _, d := range drifts {
+				w.opts.Logger.Warnf("Drift: %s", d)
+			}
+			if len(drifts) > 0 {
+				if cfg.DriftAction == DriftAbort {
+					return fmt.Errorf("%d managed resource(s) drifted from the recorded state, not syncing (DRIFT_ACTION=abort)", len(drifts))
+				}
+				w.opts.Logger.Infof("Repairing %d drifted resource(s)", len(drifts))
+				invalidate(st, drifts)
+			}
+		}
+
+		// Adopted lists have no recorded hash, so they are rewritten if still needed
+		for _, l := range adopt {
+			w.opts.Logger.Warnf("Adopting untracked list %s (%s)", l.Name, l.ID)
+			st.SetList(l)
+		}
+		if len(adopt) > 0 {
+			save()
 		}
-		wirefilterBuilder.WriteString(fmt.Sprintf("any(dns.domains[*] in $%s)", id))
 	}
-	wirefilterExpr := wirefilterBuilder.String()
 
-	filters := []string{"dns"}
-	if err := client.CreateOrUpdateRule(ctx, "Go-CFGW Filter Lists", wirefilterExpr, filters, cfg.BlockPageEnabled); err != nil {
-		return fmt.Errorf("create dns rule: %w", err)
+	// Step 2: Bring blocklist and allowlist chunks up to date. The allowlist is planned
+	// first, like it is budgeted, and the blocklist may use the lists it leaves over.
+	prevAllow, prevBlock := trackedLists(st, "allow"), trackedLists(st, "block")
+	blockLists := max(len(prevBlock), (len(block)+itemsPerList-1)/itemsPerList)
+	allowChunks, err := planChunks("allow", "Go-CFGW Allow List", allow, itemsPerList, lists-blockLists, prevAllow)
+	if err != nil {
+		return err
+	}
+	blockChunks, err := planChunks("block", "Go-CFGW Block List", block, itemsPerList, lists-max(len(prevAllow), len(allowChunks)), prevBlock)
+	if err != nil {
+		return err
+	}
+	w.opts.Logger.Infof("Syncing blocklists with %d total entries...", len(block))
+	if err := w.syncLists(ctx, s, cfg, blockChunks); err != nil {
+		return fmt.Errorf("sync block lists: %w", err)
+	}
+	w.opts.Logger.Infof("Syncing allowlists with %d total entries...", len(allow))
+	if err := w.syncLists(ctx, s, cfg, allowChunks); err != nil {
+		return fmt.Errorf("sync allow lists: %w", err)
 	}
 
-	// Optionally create SNI-based rule if configured
-	if cfg.BlockBasedOnSNI {
-		w.opts.Logger.Infof("Creating SNI-based rule for %d list(s)...", len(createdListIDs))
+	// Step 3: Point the rules at the current lists
+	var listIDs []string
+	for _, c := range sum.Chunks {
+		if c.ID != "" {
+			listIDs = append(listIDs, c.ID)
+		}
+	}
+	if err := w.syncRules(ctx, s, cfg, listIDs); err != nil {
+		return err
+	}
 
-		// Build SNI wirefilter expression
-		// Format: any(net.sni.domains[*] in $listID1) or any(net.sni.domains[*] in $listID2) or ...
-		var wirefilterSNIBuilder strings.Builder
-		for i, id := range createdListIDs {
-			if i > 0 {
-				wirefilterSNIBuilder.WriteString(" or ")
+	// Step 4: Delete lists that are no longer needed, now that no rule references them
+	wanted := map[string]bool{}
+	for _, c := range append(blockChunks, allowChunks...) {
+		wanted[c.Kind+"\x00"+c.Name] = true
+	}
+	for _, l := range append([]state.List(nil), st.Lists...) {
+		if wanted[l.Kind+"\x00"+l.Name] {
+			continue
+		}
+		if w.opts.DryRun {
+			w.opts.Logger.Infof("dry-run: would delete obsolete list %s (%s)", l.Name, l.ID)
+			sum.record("list", "deleted", l.Name, l.ID, l.Count)
+			continue
+		}
+		if _, exists := s.lists[l.ID]; exists {
+			w.opts.Logger.Infof("Deleting obsolete list %s", l.Name)
+			dctx, span := tracing.Start(ctx, "delete list", tracing.String("list.name", l.Name), tracing.String("list.id", l.ID))
+			err := client.DeleteList(dctx, l.ID)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				return fmt.Errorf("delete list %s: %w", l.Name, err)
 			}
-			wirefilterSNIBuilder.WriteString(fmt.Sprintf("any(net.sni.domains[*] in $%s)", id))
+			sum.Deleted++
+			sum.record("list", "deleted", l.Name, l.ID, l.Count)
+			listsChanged.Inc("deleted")
 		}
-		wirefilterSNIExpr := wirefilterSNIBuilder.String()
+		st.RemoveList(l.ID)
+		save()
+	}
+
+	w.opts.Logger.Infof("Successfully updated Cloudflare Gateway! %d created, %d updated, %d unchanged, %d deleted",
+		sum.Created, sum.Updated, sum.Unchanged, sum.Deleted)
+	return nil
+}
+
+// chunkKind returns the kind of a list named exactly like one of go-cfgw's chunks, or
+// "" for any other name.
+func chunkKind(name string) string {
+	switch {
+	case !cf.IsManagedList(name):
+		return ""
+	case strings.HasPrefix(name, "Go-CFGW Block List - "):
+		return "block"
+	case strings.HasPrefix(name, "Go-CFGW Allow List - "):
+		return "allow"
+	}
+	return ""
+}
 
-		sniFilters := []string{"l4"}
-		if err := client.CreateOrUpdateRule(ctx, "Go-CFGW Filter Lists - SNI Based Filtering", wirefilterSNIExpr, sniFilters, cfg.BlockPageEnabled); err != nil {
-			return fmt.Errorf("create sni rule: %w", err)
+// trackedLists returns the lists of kind recorded in the state.
+func trackedLists(st *state.State, kind string) []state.List {
+	var out []state.List
+	for _, l := range st.Lists {
+		if l.Kind == kind {
+			out = append(out, l)
 		}
 	}
+	return out
+}
 
-	w.opts.Logger.Infof("Successfully updated Cloudflare Gateway!")
-	return nil
+// client returns the configured Cloudflare client or a new one for cfg.
+func (w *Worker) client(cfg *config.Config) *cf.Client {
+	if w.opts.Client != nil {
+		return w.opts.Client
+	}
+	return cf.NewClient(cfg, w.opts.Logger)
 }
 
-func (w *Worker) createListsInChunks(ctx context.Context, client *cf.Client, cfg *config.Config, baseName string, items []string) ([]string, error) {
-	size := cfg.ListItemSize
-	total := len(items)
+// loadState reads the state file. A missing, unreadable or disabled state file, or one
+// recorded for another account, yields an empty state and the legacy cleanup.
+func (w *Worker) loadState(cfg *config.Config) *state.State {
+	empty := &state.State{Version: state.Version, AccountID: cfg.AccountID}
+	if cfg.StateFile == "" {
+		return empty
+	}
+	st, err := state.Load(cfg.StateFile)
+	if err != nil {
+		w.opts.Logger.Warnf("Ignoring state file: %v", err)
+		return empty
+	}
+	if st.AccountID != "" && st.AccountID != cfg.AccountID {
+		w.opts.Logger.Warnf("State file %s belongs to account %s, ignoring it", cfg.StateFile, st.AccountID)
+		return empty
+	}
+	st.AccountID = cfg.AccountID
+	if st.Empty() {
+		w.opts.Logger.Infof("No state recorded yet, falling back to name-based cleanup")
+	} else {
+		w.opts.Logger.Infof("Loaded state: %d list(s), %d rule(s)", len(st.Lists), len(st.Rules))
+	}
+	return st
+}
 
-	// Safety check: ensure we have items to process
-	if total == 0 {
-		w.opts.Logger.Infof("No items to create lists for")
-		return []string{}, nil
+// cleanupLegacy deletes rules and lists that have go-cfgw's or CGPS's exact names.
+func (w *Worker) cleanupLegacy(ctx context.Context, client *cf.Client) (err error) {
+	ctx, span := tracing.Start(ctx, "cleanup")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	if w.opts.DryRun {
+		w.opts.Logger.Infof("dry-run: would delete old CGPS and Go-CFGW rules and lists")
+		return nil
+	}
+	w.opts.Logger.Infof("Cleaning up old rules...")
+	if err := client.DeleteAllOldRules(ctx); err != nil {
+		return fmt.Errorf("cleanup old rules: %w", err)
+	}
+	w.opts.Logger.Infof("Cleaning up old lists...")
+	if err := client.DeleteAllOldLists(ctx); err != nil {
+		return fmt.Errorf("cleanup old lists: %w", err)
 	}
+	// Brief pause to let API settle after deletions
+	time.Sleep(settleDelay)
+	return nil
+}
 
-	// Safety check: ensure chunk size is valid
-	if size <= 0 {
-		return nil, fmt.Errorf("invalid chunk size: %d", size)
+// syncLists creates or updates one Cloudflare list per chunk. Lists whose content hash
+// and item count match the state are left untouched. Up to cfg.ChunkConcurrency lists
+// are written at once; the first error cancels the writes still in flight. Chunks are
+// appended to the summary in order once all writes have ended, so it covers the lists
+// written so far also on error, and the state is saved after every write.
+func (w *Worker) syncLists(ctx context.Context, s *reconciler, cfg *config.Config, chunks []chunk) error {
+	type job struct {
+		i      int
+		c      chunk
+		action string // "created", "updated" or "unchanged"
+		done   bool
+	}
+	jobs := make([]*job, len(chunks))
+	var pending []*job
+	ranged := false // ranges of unchanged lists were added to the state
+	for i, c := range chunks {
+		log := w.opts.Logger.With("list", c.Name, "chunk", i+1)
+		j := &job{i: i, c: c, action: "created"}
+		jobs[i] = j
+		prev := s.st.List(c.Kind, c.Name)
+		remote, exists := cf.List{}, false
+		if prev != nil {
+			remote, exists = s.lists[prev.ID]
+			if !exists {
+				log.Warnf("List %s (%s) no longer exists in Cloudflare, recreating it", c.Name, prev.ID)
+			}
+		}
+		if exists {
+			j.c.ID, j.action = prev.ID, "updated"
+			if prev.Hash == c.hash && remote.Count == c.Count && remote.Name == c.Name {
+				j.action, j.done = "unchanged", true
+				log.Debugf("List %s is unchanged", c.Name)
+				if prev.First != c.First || prev.Last != c.Last {
+					// Recorded by a release that did not keep ranges yet
+					prev.First, prev.Last = c.First, c.Last
+					ranged = true
+				}
+				continue
+			}
+		}
+		if w.opts.DryRun {
+			verb := "create"
+			if exists {
+				verb = "update"
+			}
+			log.Infof("dry-run: would %s list %s with %d items", verb, c.Name, c.Count)
+			j.done = true
+			continue
+		}
+		pending = append(pending, j)
+	}
+	if ranged && !w.opts.DryRun {
+		s.save()
 	}
 
-	chunks := int(math.Ceil(float64(total) / float64(size)))
+	var mu sync.Mutex // guards s.lists, s.st and saving the state
+	err := forEachLimit(ctx, cfg.ChunkConcurrency, len(pending), func(ctx context.Context, k int) error {
+		j := pending[k]
+		c := &j.c
+		log := w.opts.Logger.With("list", c.Name, "chunk", j.i+1)
 
-	w.opts.Logger.Infof("Will create %d list(s) with chunk size %d", chunks, size)
+		// Pre-allocate slice with exact capacity for efficiency
+		payload := make([]map[string]any, 0, len(c.items))
+		for _, v := range c.items {
+			payload = append(payload, map[string]any{"value": v})
+		}
 
-	var createdIDs []string
-	for i := 0; i < chunks; i++ {
-		start := i * size
-		end := (i + 1) * size
-		// Bounds checking: ensure end doesn't exceed total length
-		if end > total {
-			end = total
+		if j.action == "updated" {
+			log.Infof("Updating list %s with %d items... (%d/%d)", c.Name, len(payload), j.i+1, len(chunks))
+			cctx, span := chunkSpan(ctx, "update", *c, j.i)
+			err := s.client.UpdateList(cctx, c.ID, c.Name, payload)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				w.hintLimit(cfg, err)
+				return fmt.Errorf("update list %s: %w", c.Name, err)
+			}
+		} else {
+			log.Infof("Creating list %s with %d items... (%d/%d)", c.Name, len(payload), j.i+1, len(chunks))
+			cctx, span := chunkSpan(ctx, "create", *c, j.i)
+			resp, err := s.client.CreateList(cctx, c.Name, payload)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				w.hintLimit(cfg, err)
+				return fmt.Errorf("create list %s: %w", c.Name, err)
+			}
+			// Extract the list ID from response
+			if result, ok := resp["result"].(map[string]any); ok {
+				c.ID, _ = result["id"].(string)
+			}
+			if c.ID == "" {
+				return fmt.Errorf("list %s created but ID not found in response", c.Name)
+			}
 		}
+		listsChanged.Inc(j.action)
+
+		mu.Lock()
+		defer mu.Unlock()
+		j.done = true
+		s.lists[c.ID] = cf.List{ID: c.ID, Name: c.Name, Count: c.Count}
+		s.st.SetList(state.List{Kind: c.Kind, Name: c.Name, ID: c.ID, Hash: c.hash, Count: c.Count, First: c.First, Last: c.Last})
+		s.save()
+		return nil
+	})
 
-		// Additional safety: verify slice bounds
-		if start >= total {
-			w.opts.Logger.Warnf("Skipping chunk %d: start index %d >= total %d", i+1, start, total)
+	// Record in chunk order whatever was done, so rules reference lists in a stable order
+	for _, j := range jobs {
+		if !j.done {
 			continue
 		}
+		// The counters leave out what a dry-run only planned
+		switch {
+		case j.action == "unchanged":
+			s.sum.Unchanged++
+		case w.opts.DryRun:
+		case j.action == "created":
+			s.sum.Created++
+		case j.action == "updated":
+			s.sum.Updated++
+		}
+		s.sum.record("list", j.action, j.c.Name, j.c.ID, j.c.Count)
+		s.sum.Chunks = append(s.sum.Chunks, j.c.Chunk)
+	}
+	return err
+}
 
-		chunk := items[start:end]
+// forEachLimit calls fn for 0..n-1 with at most limit calls running at once. The first
+// error stops new calls from starting, but the calls already running are left to finish
+// so that no write is abandoned half way; it is returned once every started call has
+// ended.
+func forEachLimit(ctx context.Context, limit, n int, fn func(ctx context.Context, i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > n {
+		limit = n
+	}
 
-		// Safety check: ensure we have items in this chunk
-		if len(chunk) == 0 {
-			w.opts.Logger.Warnf("Skipping empty chunk %d", i+1)
-			continue
+	next := make(chan int)
+	failed := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(ctx, i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		// A failure wins over a worker being ready for more
+		select {
+		case <-failed:
+			break feed
+		default:
+		}
+		select {
+		case next <- i:
+		case <-failed:
+			break feed
+		case <-ctx.Done():
+			break feed
 		}
+	}
+	close(next)
+	wg.Wait()
 
-		// Pre-allocate slice with exact capacity for efficiency
-		payload := make([]map[string]any, 0, len(chunk))
-		for _, v := range chunk {
-			payload = append(payload, map[string]any{"value": v})
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// chunkSpan starts the span of writing a chunk, named like "create block chunk 3".
+func chunkSpan(ctx context.Context, action string, c chunk, i int) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, fmt.Sprintf("%s %s chunk %d", action, c.Kind, i+1),
+		tracing.String("list.name", c.Name), tracing.String("list.kind", c.Kind), tracing.Int("list.items", c.Count))
+}
+
+// hintLimit explains list limit errors reported while writing a list.
+// The limit is remembered in the state file, so without one, or when the size is set
+// explicitly, it has to be changed by hand.
+func (w *Worker) hintLimit(cfg *config.Config, err error) {
+	var lerr *cf.LimitError
+	if !errors.As(err, &lerr) || lerr.Kind != "items" || lerr.Max <= 0 {
+		return
+	}
+	if cfg.ListItemSize > 0 {
+		w.opts.Logger.Errorf("Cloudflare allows %d items per list on this account, fewer than CLOUDFLARE_LIST_ITEM_SIZE=%d; lower it to %d", lerr.Max, cfg.ListItemSize, lerr.Max)
+		return
+	}
+	if cfg.StateFile == "" || w.opts.DryRun {
+		w.opts.Logger.Errorf("Cloudflare allows %d items per list on this account; set CLOUDFLARE_LIST_ITEM_SIZE=%d", lerr.Max, lerr.Max)
+		return
+	}
+	w.opts.Logger.Errorf("Cloudflare allows %d items per list on this account; the next run will use that size (or set CLOUDFLARE_LIST_ITEM_SIZE=%d)", lerr.Max, lerr.Max)
+}
+
+// syncRules creates or updates the DNS rule, and the SNI rule if enabled, so that they
+// match exactly listIDs. Rules that are no longer wanted are deleted.
+func (w *Worker) syncRules(ctx context.Context, s *reconciler, cfg *config.Config, listIDs []string) error {
+	// Build wirefilter expression matching Node.js implementation
+	// Format: any(dns.domains[*] in $listID1) or any(dns.domains[*] in $listID2) or ...
+	// Use strings.Builder for efficient and safe string concatenation
+	expr := func(field string) string {
+		var b strings.Builder
+		for i, id := range listIDs {
+			if i > 0 {
+				b.WriteString(" or ")
+			}
+			b.WriteString(fmt.Sprintf("any(%s[*] in $%s)", field, id))
 		}
-		name := fmt.Sprintf("%s - Chunk %d", baseName, i+1)
+		return b.String()
+	}
 
-		if w.opts.DryRun {
-			w.opts.Logger.Infof("dry-run: would create list %s with %d items", name, len(payload))
+	want := map[string]bool{"dns": len(listIDs) > 0, "sni": len(listIDs) > 0 && cfg.BlockBasedOnSNI}
+	if len(listIDs) == 0 && !w.opts.DryRun {
+		w.opts.Logger.Infof("No lists created, skipping rule creation")
+	}
+	for _, key := range []string{"dns", "sni"} {
+		name := ruleNames[key]
+		log := w.opts.Logger.With("rule", name)
+		prev := s.st.Rule(key)
+		exists := false
+		if prev != nil {
+			_, exists = s.rules[prev.ID]
+		}
+
+		if !want[key] {
+			if prev == nil {
+				continue
+			}
+			if w.opts.DryRun {
+				log.Infof("dry-run: would delete rule %s", name)
+				s.sum.record("rule", "deleted", name, prev.ID, 0)
+				continue
+			}
+			if exists {
+				log.Infof("Deleting rule %s", name)
+				if err := s.client.DeleteRule(ctx, prev.ID); err != nil {
+					return fmt.Errorf("delete rule %s: %w", name, err)
+				}
+				s.sum.Deleted++
+				s.sum.record("rule", "deleted", name, prev.ID, 0)
+			}
+			s.st.RemoveRule(key)
+			s.save()
 			continue
 		}
 
-		w.opts.Logger.Infof("Creating list %s with %d items... (%d/%d lists remaining)", name, len(payload), chunks-i, chunks)
-		resp, err := client.CreateList(ctx, name, payload)
-		if err != nil {
-			return nil, fmt.Errorf("create list %s: %w", name, err)
+		traffic, filters := expr("dns.domains"), []string{"dns"}
+		if key == "sni" {
+			// Format: any(net.sni.domains[*] in $listID1) or any(net.sni.domains[*] in $listID2) or ...
+			traffic, filters = expr("net.sni.domains"), []string{"l4"}
+		}
+		hash := state.Hash(name, traffic, strings.Join(filters, ","), strconv.FormatBool(cfg.BlockPageEnabled))
+		spec := cf.RuleSpec{Name: name, Traffic: traffic, Filters: filters, BlockPageEnabled: cfg.BlockPageEnabled}
+		if prev != nil {
+			// Keep the rule where it was in the policy order, also when recreating it
+			spec.Precedence = prev.Precedence
 		}
 
-		// Extract the list ID from response
-		if result, ok := resp["result"].(map[string]any); ok {
-			if id, ok := result["id"].(string); ok {
-				createdIDs = append(createdIDs, id)
+		var rule cf.Rule
+		switch {
+		case exists && prev.Hash == hash:
+			log.Debugf("Rule %s is unchanged", name)
+			s.sum.Unchanged++
+			s.sum.record("rule", "unchanged", name, prev.ID, 0)
+			continue
+		case w.opts.DryRun:
+			log.Infof("dry-run: would create or update rule %s for %d list(s)", name, len(listIDs))
+			if exists {
+				s.sum.record("rule", "updated", name, prev.ID, 0)
 			} else {
-				w.opts.Logger.Warnf("List %s created but ID not found in response", name)
+				s.sum.record("rule", "created", name, "", 0)
 			}
-		} else {
-			w.opts.Logger.Warnf("List %s created but result not found in response", name)
+			continue
+		case exists:
+			log.Infof("Updating rule %s for %d list(s)...", name, len(listIDs))
+			rctx, span := tracing.Start(ctx, "upsert rule", tracing.String("rule.name", name), tracing.String("action", "update"))
+			r, err := s.client.UpdateRule(rctx, prev.ID, spec)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				return fmt.Errorf("update %s rule: %w", key, err)
+			}
+			rule = r
+			s.sum.Updated++
+			s.sum.record("rule", "updated", name, prev.ID, 0)
+		default:
+			if prev != nil {
+				log.Warnf("Rule %s (%s) no longer exists in Cloudflare, recreating it", name, prev.ID)
+			}
+			log.Infof("Creating rule %s for %d list(s)...", name, len(listIDs))
+			rctx, span := tracing.Start(ctx, "upsert rule", tracing.String("rule.name", name), tracing.String("action", "create"))
+			r, err := s.client.CreateRule(rctx, spec)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				return fmt.Errorf("create %s rule: %w", key, err)
+			}
+			rule = r
+			s.sum.Created++
+			s.sum.record("rule", "created", name, r.ID, 0)
+		}
+		if rule.ID == "" && prev != nil {
+			rule.ID = prev.ID
 		}
+		if rule.ID == "" {
+			return fmt.Errorf("rule %s written but ID not found in response", name)
+		}
+		s.rules[rule.ID] = rule
+		s.st.SetRule(state.Rule{Key: key, Name: name, ID: rule.ID, Hash: hash, Traffic: traffic, Precedence: rule.Precedence})
+		s.save()
+	}
+	return nil
+}
+
+// planLimits returns the chunk size and the number of lists this run may use. Limits
+// are discovered from the account unless overridden, and the others lists owned by
+// other tools are subtracted since they count against the same quota.
+func (w *Worker) planLimits(ctx context.Context, client *cf.Client, cfg *config.Config, others int) (itemsPerList, lists int) {
+	limits := client.DiscoverLimits(ctx)
+	// Explicit settings win over everything discovered, including reported limits
+	if cfg.ListItemSize > 0 {
+		limits.MaxItemsPerList = cfg.ListItemSize
+		limits.Source = "override"
+	}
+	if cfg.MaxLists > 0 {
+		limits.MaxLists = cfg.MaxLists
+		limits.Source = "override"
+	}
+
+	lists = limits.MaxLists - others
+	if lists < 0 {
+		lists = 0
+	}
+	maxItems := lists * limits.MaxItemsPerList
+	if cfg.ListItemLimit > 0 && cfg.ListItemLimit < maxItems {
+		maxItems = cfg.ListItemLimit
+	}
+	w.opts.Logger.Infof("List limits (%s): %d lists x %d items, %d list(s) used by other tools, up to %d entries this run",
+		limits.Source, limits.MaxLists, limits.MaxItemsPerList, others, maxItems)
+	return limits.MaxItemsPerList, lists
+}
 
-		w.opts.Logger.Infof("Created %s successfully - %d list(s) remaining", name, chunks-i-1)
+// logTruncation reports which sources lost entries to the item limit.
+func (w *Worker) logTruncation(t *downloader.Truncation) {
+	budget := fmt.Sprintf("%d list(s) of %d items", t.Lists, t.ListSize)
+	if t.Limit > 0 {
+		budget += fmt.Sprintf(" and the limit of %d", t.Limit)
+	}
+	w.opts.Logger.Warnf("Total items (%d) do not fit in %s, dropped %d lowest ranked entries", t.Before, budget, len(t.Dropped))
+	urls := make([]string, 0, len(t.DroppedBySource))
+	for u := range t.DroppedBySource {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for _, u := range urls {
+		w.opts.Logger.Warnf("  %s: %d entries dropped", u, t.DroppedBySource[u])
+	}
+	for i, d := range t.Dropped {
+		if i == 20 {
+			w.opts.Logger.Debugf("  ... and %d more", len(t.Dropped)-i)
+			break
+		}
+		w.opts.Logger.Debugf("  dropped: %s", d)
 	}
-	return createdIDs, nil
 }
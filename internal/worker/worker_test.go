@@ -0,0 +1,439 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galpt/go-cfgw/internal/cf"
+	"github.com/galpt/go-cfgw/internal/cftest"
+	"github.com/galpt/go-cfgw/internal/config"
+	"github.com/galpt/go-cfgw/internal/downloader"
+	"github.com/galpt/go-cfgw/internal/logging"
+	"github.com/galpt/go-cfgw/internal/state"
+)
+
+// setup starts a fake API and loads a configuration pointing at it, with the
+// client-side rate limit off and a state file in a temporary directory. env overrides
+// further variables.
+func setup(t *testing.T, opts cftest.Options, env map[string]string) (*cftest.Server, *config.Config) {
+	t.Helper()
+	delay := settleDelay
+	settleDelay = 0
+	t.Cleanup(func() { settleDelay = delay })
+	srv := cftest.NewServer(t, opts)
+	srv.Setenv(t)
+	t.Setenv("CLOUDFLARE_RATE_LIMIT", "off")
+	t.Setenv("STATE_FILE", filepath.Join(t.TempDir(), "state.json"))
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return srv, cfg
+}
+
+func newWorker(client *cf.Client) *Worker {
+	return New(Options{Logger: logging.New(logging.Options{Output: io.Discard}), Client: client})
+}
+
+func result(block, allow []string) *downloader.Result {
+	return &downloader.Result{Block: append([]string(nil), block...), Allow: append([]string(nil), allow...)}
+}
+
+func domains(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("d%03d.example.com", i)
+	}
+	return out
+}
+
+// writes returns the number of write requests the server answered.
+func writes(srv *cftest.Server) int {
+	return srv.Count("POST", "") + srv.Count("PUT", "") + srv.Count("DELETE", "")
+}
+
+var listRef = regexp.MustCompile(`\$([0-9a-f-]+)`)
+
+// checkRules fails the test unless every rule only references existing lists and,
+// when want is given, references exactly those lists in that order.
+func checkRules(t *testing.T, srv *cftest.Server, want ...string) {
+	t.Helper()
+	lists := map[string]bool{}
+	for _, l := range srv.Lists() {
+		lists[l.ID] = true
+	}
+	for _, r := range srv.Rules() {
+		var refs []string
+		for _, m := range listRef.FindAllStringSubmatch(r.Traffic, -1) {
+			id := m[1]
+			if !lists[id] {
+				t.Errorf("rule %s references missing list %s", r.Name, id)
+			}
+			refs = append(refs, id)
+		}
+		if want != nil && strings.Join(refs, ",") != strings.Join(want, ",") {
+			t.Errorf("rule %s references %v, want %v", r.Name, refs, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "2", "BLOCK_BASED_ON_SNI": "true"})
+	ctx := context.Background()
+	// Leftovers of the original scripts, and a list of another tool that must survive
+	legacy := srv.AddList("CGPS List - Chunk 1", "old.example.com")
+	srv.AddRule("CGPS Filter Lists", "any(dns.domains[*] in $"+legacy.ID+")")
+	other := srv.AddList("Someone else's list", "keep.example.com")
+
+	block, allow := domains(5), []string{"ok.example.com"}
+	sum, err := newWorker(nil).Run(ctx, cfg, result(block, allow))
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if sum.Created != 6 || sum.Updated != 0 || sum.Deleted != 0 {
+		t.Errorf("first run: %d created, %d updated, %d deleted; want 4 lists and 2 rules created", sum.Created, sum.Updated, sum.Deleted)
+	}
+	lists := srv.Lists()
+	if len(lists) != 5 || lists[0].ID != other.ID {
+		t.Fatalf("lists after first run = %+v, want the other tool's and 4 new ones", lists)
+	}
+	byID := map[string]cftest.List{}
+	for _, l := range lists {
+		byID[l.ID] = l
+	}
+	var ids []string
+	for _, c := range sum.Chunks {
+		if l := byID[c.ID]; l.Name != c.Name || len(l.Items) != c.Count {
+			t.Errorf("chunk %+v, want it to match list %+v", c, l)
+		}
+		ids = append(ids, c.ID)
+	}
+	if rules := srv.Rules(); len(rules) != 2 {
+		t.Fatalf("rules after first run = %+v, want DNS and SNI rules", rules)
+	}
+	checkRules(t, srv, ids...)
+
+	// Nothing changed, nothing is written
+	before := writes(srv)
+	sum, err = newWorker(nil).Run(ctx, cfg, result(block, allow))
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if sum.Unchanged != 6 || sum.Created+sum.Updated+sum.Deleted != 0 {
+		t.Errorf("second run: %+v, want all 6 resources unchanged", sum)
+	}
+	if n := writes(srv) - before; n != 0 {
+		t.Errorf("second run made %d writes, want none", n)
+	}
+
+	// Fewer entries: the second chunk shrinks, the third goes after the rules moved on
+	sum, err = newWorker(nil).Run(ctx, cfg, result(block[:3], allow))
+	if err != nil {
+		t.Fatalf("third run: %v", err)
+	}
+	if sum.Updated != 3 || sum.Deleted != 1 || sum.Unchanged != 2 {
+		t.Errorf("third run: %d updated, %d deleted, %d unchanged; want 1 list and 2 rules updated, 1 list deleted, 2 lists unchanged",
+			sum.Updated, sum.Deleted, sum.Unchanged)
+	}
+	if lists := srv.Lists(); len(lists) != 4 {
+		t.Errorf("lists after third run = %+v, want the other tool's and 3", lists)
+	}
+	checkRules(t, srv, ids[0], ids[1], ids[3])
+}
+
+func TestRunRewritesOnlyChangedChunks(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "10"})
+	ctx := context.Background()
+	block := domains(40)
+	sum, err := newWorker(nil).Run(ctx, cfg, result(block, nil))
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	// Lists are filled to nine tenths, leaving room to grow
+	if sum.Created != 6 || len(sum.Chunks) != 5 || sum.Chunks[0].Count != 9 {
+		t.Fatalf("first run: %d created, chunks %+v; want 5 lists of up to 9 items and the rule", sum.Created, sum.Chunks)
+	}
+
+	// An entry near the start only changes the first list, not every list after it
+	block = append([]string{block[0], "d000a.example.com"}, block[1:]...)
+	before := writes(srv)
+	if sum, err = newWorker(nil).Run(ctx, cfg, result(block, nil)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if sum.Updated != 1 || sum.Unchanged != 5 || sum.Created+sum.Deleted != 0 {
+		t.Errorf("insert: %+v, want only the first list updated", sum)
+	}
+	if n := writes(srv) - before; n != 1 {
+		t.Errorf("insert made %d writes, want 1", n)
+	}
+	for _, l := range srv.Lists() {
+		if l.Name == "Go-CFGW Block List - Chunk 1" && len(l.Items) != 10 {
+			t.Errorf("chunk 1 holds %d items, want 10", len(l.Items))
+		}
+	}
+
+	// A list that outgrows the size is split, the rest stay as they are
+	block = append([]string{block[0], "d000b.example.com"}, block[1:]...)
+	if sum, err = newWorker(nil).Run(ctx, cfg, result(block, nil)); err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	if sum.Updated != 2 || sum.Created != 1 || sum.Unchanged != 4 {
+		t.Errorf("split: %d updated, %d created, %d unchanged; want chunk 1 and the rule updated, 1 list created, 4 unchanged",
+			sum.Updated, sum.Created, sum.Unchanged)
+	}
+	var ids []string
+	for i, c := range sum.Chunks {
+		if i > 0 && c.First <= sum.Chunks[i-1].Last {
+			t.Errorf("chunk %s starts at %s, before the previous one ends", c.Name, c.First)
+		}
+		ids = append(ids, c.ID)
+	}
+	checkRules(t, srv, ids...)
+
+	// Removing entries only changes the lists that held them
+	block = append(block[:20:20], block[21:]...)
+	if sum, err = newWorker(nil).Run(ctx, cfg, result(block, nil)); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if sum.Updated != 1 || sum.Unchanged != 6 {
+		t.Errorf("remove: %d updated, %d unchanged; want 1 list updated", sum.Updated, sum.Unchanged)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, nil)
+	w := New(Options{Logger: logging.New(logging.Options{Output: io.Discard}), DryRun: true})
+	sum, err := w.Run(context.Background(), cfg, result(domains(3), nil))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if n := writes(srv); n != 0 {
+		t.Errorf("dry-run made %d writes", n)
+	}
+	// Without list IDs there is no rule to plan yet
+	if len(sum.Changes) != 1 || sum.Changes[0].Action != "created" || sum.Created != 0 {
+		t.Errorf("dry-run summary = %+v, want a list planned but not counted", sum)
+	}
+}
+
+func TestRunResumesAfterFailedChunk(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "1", "CHUNK_CONCURRENCY": "1"})
+	ctx := context.Background()
+	srv.Inject(cftest.Fault{Method: "POST", Name: "Go-CFGW Block List - Chunk 3", Status: http.StatusBadRequest, Code: 2001, Message: "invalid list"})
+
+	_, err := newWorker(nil).Run(ctx, cfg, result(domains(5), nil))
+	if err == nil || !strings.Contains(err.Error(), "Chunk 3") {
+		t.Fatalf("err = %v, want chunk 3 to fail", err)
+	}
+	if lists, rules := srv.Lists(), srv.Rules(); len(lists) != 2 || len(rules) != 0 {
+		t.Fatalf("after the failure: %d lists and %d rules, want the 2 chunks before it and no rule", len(lists), len(rules))
+	}
+
+	// The next run keeps what the failed one wrote
+	srv.ClearFaults()
+	sum, err := newWorker(nil).Run(ctx, cfg, result(domains(5), nil))
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if sum.Unchanged != 2 || sum.Created != 4 {
+		t.Errorf("second run: %d unchanged, %d created; want 2 lists kept, 3 lists and the rule created", sum.Unchanged, sum.Created)
+	}
+	if lists := srv.Lists(); len(lists) != 5 {
+		t.Errorf("%d lists after the second run, want 5", len(lists))
+	}
+	checkRules(t, srv)
+}
+
+func TestRunAdoptsUntrackedChunks(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "1"})
+	ctx := context.Background()
+	if _, err := newWorker(nil).Run(ctx, cfg, result(domains(2), nil)); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	// Chunks a run created before it could record them in the state file
+	srv.AddList("Go-CFGW Block List - Chunk 3", "stale.example.com")
+	srv.AddList("Go-CFGW Block List - Chunk 5", "stale.example.com")
+
+	sum, err := newWorker(nil).Run(ctx, cfg, result(domains(3), nil))
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if sum.Created != 0 || sum.Deleted != 1 {
+		t.Errorf("second run: %d created, %d deleted; want chunk 3 reused and chunk 5 deleted", sum.Created, sum.Deleted)
+	}
+	lists := srv.Lists()
+	if len(lists) != 3 {
+		t.Fatalf("%d lists, want 3", len(lists))
+	}
+	for _, l := range lists {
+		if l.Name == "Go-CFGW Block List - Chunk 3" && (len(l.Items) != 1 || l.Items[0] != "d002.example.com") {
+			t.Errorf("chunk 3 holds %v, want the third domain", l.Items)
+		}
+	}
+	checkRules(t, srv)
+}
+
+func TestRunFinishesInFlightChunksAfterFailure(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{WriteLatency: 100 * time.Millisecond}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "1", "CHUNK_CONCURRENCY": "3"})
+	srv.Inject(cftest.Fault{Method: "POST", Name: "Go-CFGW Block List - Chunk 2", Status: http.StatusBadRequest, Code: 2001, Message: "invalid list"})
+
+	_, err := newWorker(nil).Run(context.Background(), cfg, result(domains(6), nil))
+	if err == nil || !strings.Contains(err.Error(), "Chunk 2") {
+		t.Fatalf("err = %v, want chunk 2 to fail", err)
+	}
+	// Chunks created while chunk 2 failed are recorded, not orphaned
+	st, err := state.Load(cfg.StateFile)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	tracked := map[string]bool{}
+	for _, l := range st.Lists {
+		tracked[l.ID] = true
+	}
+	lists := srv.Lists()
+	if len(lists) != 2 {
+		t.Errorf("%d lists after the failure, want the 2 chunks in flight with chunk 2", len(lists))
+	}
+	for _, l := range lists {
+		if !tracked[l.ID] {
+			t.Errorf("list %s (%s) is not in the state file", l.Name, l.ID)
+		}
+	}
+}
+
+func TestRunWritesChunksConcurrently(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{Latency: 20 * time.Millisecond}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "1", "CHUNK_CONCURRENCY": "3"})
+	sum, err := newWorker(nil).Run(context.Background(), cfg, result(domains(9), nil))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if p := srv.PeakInFlight(); p < 2 || p > 3 {
+		t.Errorf("peak of %d concurrent requests, want 2 to 3", p)
+	}
+	// Lists are created in any order, the rule lists them in chunk order
+	var ids []string
+	for i, c := range sum.Chunks {
+		if want := fmt.Sprintf("Go-CFGW Block List - Chunk %d", i+1); c.Name != want {
+			t.Errorf("chunk %d is %s, want %s", i+1, c.Name, want)
+		}
+		ids = append(ids, c.ID)
+	}
+	checkRules(t, srv, ids...)
+}
+
+func TestRunRetriesRateLimited(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, nil)
+	f := cftest.RateLimited(1)
+	f.Method = "POST"
+	srv.Inject(f)
+
+	client := cf.NewClient(cfg, logging.New(logging.Options{Output: io.Discard}))
+	if _, err := newWorker(client).Run(context.Background(), cfg, result(domains(3), nil)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if st := client.Stats(); st.RateLimited != 1 {
+		t.Errorf("%d requests rate limited, want 1", st.RateLimited)
+	}
+	if lists := srv.Lists(); len(lists) != 1 {
+		t.Errorf("%d lists, want 1", len(lists))
+	}
+}
+
+func TestRunLearnsItemLimit(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{MaxItemsPerList: 3}, nil)
+	ctx := context.Background()
+
+	// The documented 1000 items per list are too many for this account
+	_, err := newWorker(nil).Run(ctx, cfg, result(domains(7), nil))
+	var lerr *cf.LimitError
+	if !errors.As(err, &lerr) || lerr.Max != 3 {
+		t.Fatalf("err = %v, want an items limit of 3", err)
+	}
+
+	// The next run, in a new process, uses the limit remembered in the state file
+	if _, err := newWorker(nil).Run(ctx, cfg, result(domains(7), nil)); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	lists := srv.Lists()
+	if len(lists) != 3 {
+		t.Fatalf("%d lists, want 3", len(lists))
+	}
+	for _, l := range lists {
+		if len(l.Items) > 3 {
+			t.Errorf("list %s has %d items, over the limit", l.Name, len(l.Items))
+		}
+	}
+
+	// Once expired, the limit is forgotten and has to be reported again
+	st, err := state.Load(cfg.StateFile)
+	if err != nil || st.Limits == nil || st.Limits.MaxItemsPerList != 3 || st.Limits.LearnedAt.IsZero() {
+		t.Fatalf("state limits = %+v, %v; want 3 items per list with the time reported", st.Limits, err)
+	}
+	st.Limits.LearnedAt = time.Now().Add(-cf.LearnedLimitsTTL - time.Hour)
+	if err := st.Save(cfg.StateFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWorker(nil).Run(ctx, cfg, result(domains(12), nil)); !errors.As(err, &lerr) {
+		t.Fatalf("run after expiry: err = %v, want the items limit reported again", err)
+	}
+}
+
+func TestRunLearnedLimitsKeepOverride(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{MaxItemsPerList: 5}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "5"})
+	st := &state.State{Limits: &state.Limits{MaxItemsPerList: 2, LearnedAt: time.Now()}}
+	if err := st.Save(cfg.StateFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWorker(nil).Run(context.Background(), cfg, result(domains(7), nil)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if lists := srv.Lists(); len(lists) != 2 {
+		t.Errorf("%d lists, want 2 of CLOUDFLARE_LIST_ITEM_SIZE=5 items", len(lists))
+	}
+}
+
+func TestRunBudgetsWholeLists(t *testing.T) {
+	// 6 entries fit 3 lists of 2 items, but one allow entry takes a list of its own
+	srv, cfg := setup(t, cftest.Options{MaxLists: 3}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "2", "CLOUDFLARE_MAX_LISTS": "3"})
+	sum, err := newWorker(nil).Run(context.Background(), cfg, result(domains(5), []string{"ok.example.com"}))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if sum.Truncation == nil || len(sum.Truncation.Dropped) != 1 {
+		t.Fatalf("truncation = %+v, want 1 block entry dropped", sum.Truncation)
+	}
+	if lists := srv.Lists(); len(lists) != 3 {
+		t.Errorf("%d lists, want 3", len(lists))
+	}
+	checkRules(t, srv)
+}
+
+func TestRunRepairsDrift(t *testing.T) {
+	srv, cfg := setup(t, cftest.Options{}, map[string]string{"CLOUDFLARE_LIST_ITEM_SIZE": "2"})
+	ctx := context.Background()
+	sum, err := newWorker(nil).Run(ctx, cfg, result(domains(4), nil))
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	srv.RemoveList(sum.Chunks[0].ID)
+
+	sum, err = newWorker(nil).Run(ctx, cfg, result(domains(4), nil))
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if sum.Created != 1 || sum.Updated != 1 || sum.Unchanged != 1 {
+		t.Errorf("second run: %+v, want the deleted list recreated and the rule updated", sum)
+	}
+	checkRules(t, srv, sum.Chunks[0].ID, sum.Chunks[1].ID)
+}